@@ -0,0 +1,55 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+// Decoder decodes maps and string maps into structs.
+// It caches the field metadata of each struct type, so reusing a Decoder
+// is faster than calling DecodeMap and DecodeStringMap repeatedly.
+// A Decoder is safe for concurrent use by multiple goroutines.
+type Decoder struct {
+	mapOptions       DecodeMapOptions
+	stringMapOptions DecodeStringMapOptions
+	cache            fieldCache
+}
+
+type DecoderOptions struct {
+	Map       *DecodeMapOptions
+	StringMap *DecodeStringMapOptions
+}
+
+// NewDecoder returns a new decoder with the options.
+// The Metadata options are ignored, because the concurrent calls
+// would share them. Use the package-level functions to receive Metadata.
+func NewDecoder(o *DecoderOptions) *Decoder {
+	if o == nil {
+		o = &DecoderOptions{}
+	}
+	var mapOpts DecodeMapOptions
+	if o.Map != nil {
+		mapOpts = *o.Map
+	}
+	mapOpts.Metadata = nil
+	stringMapOpts := initDecodeStringMapOptions(o.StringMap)
+	stringMapOpts.Metadata = nil
+	return &Decoder{
+		mapOptions:       *initDecodeMapOptions(&mapOpts),
+		stringMapOptions: stringMapOpts,
+	}
+}
+
+// DecodeMap decodes a map into a struct.
+func (d *Decoder) DecodeMap(m map[string]interface{}, v interface{}) error {
+	return decodeMap(m, v, d.mapOptions, &d.cache)
+}
+
+// DecodeStringMap decodes a string map into a struct.
+func (d *Decoder) DecodeStringMap(m map[string]string, v interface{}) error {
+	return decodeSource(MapSource(m), v, d.stringMapOptions, &d.cache)
+}
+
+// DecodeSource decodes the source into a struct.
+func (d *Decoder) DecodeSource(src Source, v interface{}) error {
+	return decodeSource(src, v, d.stringMapOptions, &d.cache)
+}
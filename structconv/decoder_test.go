@@ -0,0 +1,194 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type benchDecoderDB struct {
+	Host     string `map:"host" strmap:"DBHost"`
+	Port     int    `map:"port" strmap:"DBPort"`
+	Username string `map:"username" strmap:"DBUsername"`
+	Password string `map:"-" strmap:"-"`
+}
+
+type benchDecoderServer struct {
+	Name string `map:"name"`
+	Port int    `map:"port"`
+}
+
+type benchDecoderConfig struct {
+	AppName string `map:",required" strmap:",required"`
+	Port    int
+	Debug   bool
+	Rate    float64
+	DB      benchDecoderDB       `map:"db"`
+	Servers []benchDecoderServer `map:"servers"`
+}
+
+var benchDecoderMap = map[string]interface{}{
+	"AppName": "myapp",
+	"Port":    8080,
+	"Debug":   true,
+	"Rate":    0.5,
+	"db": map[string]interface{}{
+		"host":     "mydb",
+		"port":     5432,
+		"username": "admin",
+	},
+	"servers": []map[string]interface{}{
+		{"name": "a", "port": 1},
+		{"name": "b", "port": 2},
+		{"name": "c", "port": 3},
+	},
+}
+
+var benchDecoderStringMap = map[string]string{
+	"AppName":    "myapp",
+	"Port":       "8080",
+	"Debug":      "true",
+	"Rate":       "0.5",
+	"DBHost":     "mydb",
+	"DBPort":     "5432",
+	"DBUsername": "admin",
+}
+
+func TestDecoder(t *testing.T) {
+	wantMap := benchDecoderConfig{
+		AppName: "myapp",
+		Port:    8080,
+		Debug:   true,
+		Rate:    0.5,
+		DB:      benchDecoderDB{Host: "mydb", Port: 5432, Username: "admin"},
+		Servers: []benchDecoderServer{{"a", 1}, {"b", 2}, {"c", 3}},
+	}
+	wantStringMap := wantMap
+	wantStringMap.Servers = nil
+
+	d := NewDecoder(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got benchDecoderConfig
+			if err := d.DecodeMap(benchDecoderMap, &got); err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(got, wantMap) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", wantMap, got)
+			}
+			got = benchDecoderConfig{}
+			if err := d.DecodeStringMap(benchDecoderStringMap, &got); err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(got, wantStringMap) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", wantStringMap, got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDecoderOptions(t *testing.T) {
+	type testDecoderOptions struct {
+		A int `json:"a"`
+		B int
+	}
+	d := NewDecoder(&DecoderOptions{
+		Map:       &DecodeMapOptions{TagName: "json", TagOnly: true},
+		StringMap: &DecodeStringMapOptions{TagName: "json"},
+	})
+
+	var got testDecoderOptions
+	if err := d.DecodeMap(map[string]interface{}{"a": 1, "B": 2}, &got); err != nil {
+		t.Error(err)
+	}
+	if want := (testDecoderOptions{A: 1}); got != want {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+	got = testDecoderOptions{}
+	if err := d.DecodeStringMap(map[string]string{"a": "1", "B": "2"}, &got); err != nil {
+		t.Error(err)
+	}
+	if want := (testDecoderOptions{A: 1, B: 2}); got != want {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+}
+
+func TestDecoderMetadata(t *testing.T) {
+	var mapMeta, stringMapMeta Metadata
+	d := NewDecoder(&DecoderOptions{
+		Map:       &DecodeMapOptions{Metadata: &mapMeta},
+		StringMap: &DecodeStringMapOptions{Metadata: &stringMapMeta},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got benchDecoderConfig
+			if err := d.DecodeMap(benchDecoderMap, &got); err != nil {
+				t.Error(err)
+			}
+			if err := d.DecodeStringMap(benchDecoderStringMap, &got); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !reflect.DeepEqual(mapMeta, Metadata{}) || !reflect.DeepEqual(stringMapMeta, Metadata{}) {
+		t.Errorf("unexpected metadata: %+v, %+v", mapMeta, stringMapMeta)
+	}
+}
+
+func BenchmarkDecodeMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var conf benchDecoderConfig
+		if err := DecodeMap(benchDecoderMap, &conf, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderDecodeMap(b *testing.B) {
+	d := NewDecoder(nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var conf benchDecoderConfig
+		if err := d.DecodeMap(benchDecoderMap, &conf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeStringMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var conf benchDecoderConfig
+		if err := DecodeStringMap(benchDecoderStringMap, &conf, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderDecodeStringMap(b *testing.B) {
+	d := NewDecoder(nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var conf benchDecoderConfig
+		if err := d.DecodeStringMap(benchDecoderStringMap, &conf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
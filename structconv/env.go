@@ -5,31 +5,80 @@
 package structconv
 
 import (
+	"bufio"
+	"errors"
+	"io"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/twihike/go-strcase/strcase"
 )
 
 const (
-	envTagName = "env"
+	envTagName    = "env"
+	envFileSuffix = "_FILE"
+	envSafeChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_-.,:/@+%"
 )
 
 type DecodeEnvOptions struct {
 	TagName      string
 	TagOnly      bool
 	KeyConverter func(string) string
+	// Prefix is prepended to all the environment variable names, such as
+	// "MYAPP_". The prefix tag option of the nested structs is appended to it.
+	Prefix string
+	// FileSuffix is the suffix of the variable naming the file to read
+	// the value of an unset variable from. The default is "_FILE",
+	// the convention of Docker and Kubernetes secrets.
+	FileSuffix string
+	// DisableFileSuffix disables reading the values from the files
+	// named by the variables with FileSuffix.
+	DisableFileSuffix bool
+	// Expand interpolates ${NAME} and ${NAME:-fallback} in the values
+	// with the other environment variables before conversion.
+	Expand bool
+}
+
+type EncodeEnvOptions struct {
+	TagName      string
+	TagOnly      bool
+	KeyConverter func(string) string
+	// Prefix is prepended to all the environment variable names, such as
+	// "MYAPP_". The prefix tag option of the nested structs is appended to it.
+	Prefix string
 }
 
 // DecodeEnv decodes environment variables into a struct.
 func DecodeEnv(v interface{}, o *DecodeEnvOptions) error {
-	m := map[string]string{}
-	for _, e := range os.Environ() {
-		pair := strings.SplitN(e, "=", 2)
-		if len(pair) == 2 {
-			m[pair[0]] = pair[1]
+	return decodeEnv(EnvSource{}, v, o)
+}
+
+// DecodeEnvFile decodes the env file and environment variables into a struct.
+// Environment variables take precedence over the variables in the file.
+func DecodeEnvFile(path string, v interface{}, o *DecodeEnvOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := ReadEnvFile(f)
+	if err != nil {
+		var fileErr *EnvFileError
+		if errors.As(err, &fileErr) {
+			fileErr.Path = path
 		}
+		return err
 	}
+	src := Layered{
+		{Name: path, Source: MapSource(m)},
+		{Name: "env", Source: EnvSource{}},
+	}
+	return decodeEnv(src, v, o)
+}
+
+func decodeEnv(src Source, v interface{}, o *DecodeEnvOptions) error {
 	if o == nil {
 		o = &DecodeEnvOptions{}
 	}
@@ -39,10 +88,107 @@ func DecodeEnv(v interface{}, o *DecodeEnvOptions) error {
 	if o.KeyConverter == nil {
 		o.KeyConverter = strcase.ToUpperSnake
 	}
+	fileSuffix := o.FileSuffix
+	if fileSuffix == "" {
+		fileSuffix = envFileSuffix
+	}
+	if o.DisableFileSuffix {
+		fileSuffix = ""
+	}
 	opts := &DecodeStringMapOptions{
 		TagName:      o.TagName,
 		TagOnly:      o.TagOnly,
 		KeyConverter: o.KeyConverter,
+		Prefix:       o.Prefix,
+		FileSuffix:   fileSuffix,
+		Expand:       o.Expand,
+	}
+	return DecodeSource(src, v, opts)
+}
+
+// EncodeEnv encodes a struct into environment variables.
+// Each entry has the form "KEY=VALUE" as used by exec.Cmd.Env,
+// and the entries are sorted by key.
+func EncodeEnv(v interface{}, o *EncodeEnvOptions) ([]string, error) {
+	m, err := encodeEnvMap(v, o)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, k := range sortedKeys(m) {
+		result = append(result, k+"="+m[k])
+	}
+	return result, nil
+}
+
+// WriteEnvFile writes a struct to w in the .env file format.
+// Values that contain anything other than letters, digits and
+// a few safe symbols are double-quoted and escaped.
+func WriteEnvFile(w io.Writer, v interface{}, o *EncodeEnvOptions) error {
+	m, err := encodeEnvMap(v, o)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, k := range sortedKeys(m) {
+		bw.WriteString(k)
+		bw.WriteByte('=')
+		bw.WriteString(quoteEnvValue(m[k]))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func encodeEnvMap(v interface{}, o *EncodeEnvOptions) (map[string]string, error) {
+	if o == nil {
+		o = &EncodeEnvOptions{}
+	}
+	if o.TagName == "" {
+		o.TagName = envTagName
+	}
+	if o.KeyConverter == nil {
+		o.KeyConverter = strcase.ToUpperSnake
+	}
+	opts := &EncodeStringMapOptions{
+		TagName:      o.TagName,
+		TagOnly:      o.TagOnly,
+		KeyConverter: o.KeyConverter,
+		Prefix:       o.Prefix,
+	}
+	return EncodeStringMap(v, opts)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func quoteEnvValue(s string) string {
+	if s != "" && strings.Trim(s, envSafeChars) == "" {
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	// Iterate over bytes to keep invalid UTF-8 sequences as they are.
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"', '$', '`':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			sb.WriteByte(c)
+		}
 	}
-	return DecodeStringMap(m, v, opts)
+	sb.WriteByte('"')
+	return sb.String()
 }
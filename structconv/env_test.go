@@ -5,7 +5,12 @@
 package structconv
 
 import (
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -60,3 +65,273 @@ func TestDecodeEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeEnvPrefix(t *testing.T) {
+	type envReplica struct {
+		Host string
+	}
+	type envDB struct {
+		Host     string
+		Port     int         `env:"PORT_NUMBER"`
+		Replica  envReplica  `env:",prefix=REPLICA_"`
+		Replica2 *envReplica `env:",prefix=REPLICA2_"`
+	}
+	type envTest struct {
+		Name string
+		DB   envDB `env:",prefix=DB_"`
+	}
+
+	tests := []struct {
+		name string
+		in   map[string]string
+		opts *DecodeEnvOptions
+		want envTest
+	}{
+		{
+			name: "tag prefix",
+			in: map[string]string{
+				"NAME":                  "a",
+				"DB_HOST":               "b",
+				"DB_PORT_NUMBER":        "1",
+				"DB_REPLICA_HOST":       "c",
+				"DB_REPLICA2_HOST":      "d",
+				"MYAPP_DB_HOST":         "x",
+				"MYAPP_DB_REPLICA_HOST": "x",
+			},
+			want: envTest{
+				Name: "a",
+				DB: envDB{
+					Host:     "b",
+					Port:     1,
+					Replica:  envReplica{Host: "c"},
+					Replica2: &envReplica{Host: "d"},
+				},
+			},
+		},
+		{
+			name: "option prefix",
+			in: map[string]string{
+				"NAME":                  "x",
+				"DB_HOST":               "x",
+				"MYAPP_NAME":            "a",
+				"MYAPP_DB_HOST":         "b",
+				"MYAPP_DB_PORT_NUMBER":  "1",
+				"MYAPP_DB_REPLICA_HOST": "c",
+			},
+			opts: &DecodeEnvOptions{Prefix: "MYAPP_"},
+			want: envTest{
+				Name: "a",
+				DB: envDB{
+					Host:     "b",
+					Port:     1,
+					Replica:  envReplica{Host: "c"},
+					Replica2: &envReplica{},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		// The cases share the environment, so they are not run in parallel.
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for k, v := range tt.in {
+				os.Setenv(k, v)
+			}
+			var got envTest
+			err := DecodeEnv(&got, tt.opts)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+
+			var eo *EncodeEnvOptions
+			if tt.opts != nil {
+				eo = &EncodeEnvOptions{Prefix: tt.opts.Prefix}
+			}
+			env, err := EncodeEnv(&got, eo)
+			if err != nil {
+				t.Error(err)
+			}
+			for _, e := range env {
+				kv := strings.SplitN(e, "=", 2)
+				if tt.in[kv[0]] != kv[1] {
+					t.Errorf("unexpected entry %q", e)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeEnvFileSuffix(t *testing.T) {
+	type envTest struct {
+		Password string
+		Token    string
+		Missing  string
+	}
+
+	dir := t.TempDir()
+	password := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(password, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]string
+		want    envTest
+		wantErr []string
+	}{
+		{
+			name: "normal",
+			in: map[string]string{
+				"PASSWORD_FILE": password,
+				"TOKEN":         "t",
+				"TOKEN_FILE":    password,
+			},
+			want: envTest{Password: "secret", Token: "t"},
+		},
+		{
+			name: "unreadable",
+			in: map[string]string{
+				"PASSWORD_FILE": filepath.Join(dir, "missing"),
+			},
+			wantErr: []string{"PASSWORD_FILE"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		// The cases share the environment, so they are not run in parallel.
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for k, v := range tt.in {
+				os.Setenv(k, v)
+			}
+			var got envTest
+			err := DecodeEnv(&got, nil)
+			if names := decodeErrorNames(err); !reflect.DeepEqual(names, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, names)
+			}
+			if got != tt.want {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+
+			// The files are not read if the suffix is disabled.
+			got = envTest{}
+			if err := DecodeEnv(&got, &DecodeEnvOptions{DisableFileSuffix: true}); err != nil {
+				t.Error(err)
+			}
+			if want := (envTest{Token: tt.in["TOKEN"]}); got != want {
+				t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeEnvFile(t *testing.T) {
+	type envTest struct {
+		Host string
+		Port int
+		Name string
+	}
+
+	path := filepath.Join(t.TempDir(), ".env")
+	content := "HOST=localhost\nPORT=8080\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Clearenv()
+	os.Setenv("PORT", "9090")
+	os.Setenv("NAME", "n")
+
+	var got envTest
+	if err := DecodeEnvFile(path, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := envTest{Host: "localhost", Port: 9090, Name: "n"}
+	if got != want {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+
+	invalid := filepath.Join(t.TempDir(), ".env")
+	if err := ioutil.WriteFile(invalid, []byte("HOST=a\nPORT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := DecodeEnvFile(invalid, &got, nil)
+	wantErr := &EnvFileError{Path: invalid, Line: 2, Message: msgEnvFileMissingEqual}
+	var fileErr *EnvFileError
+	if !errors.As(err, &fileErr) || !reflect.DeepEqual(fileErr, wantErr) {
+		t.Errorf("\nwant = %v\ngot  = %v", wantErr, err)
+	}
+}
+
+func TestEncodeEnv(t *testing.T) {
+	type envDB struct {
+		Host string `env:"DB_HOST"`
+	}
+	type envTest struct {
+		String  string
+		Bool    bool
+		Int     int
+		Float64 float64
+		Rename  string `env:"ENV_NAME"`
+		Omitted string `env:"-"`
+		DB      envDB
+	}
+
+	tests := []struct {
+		name     string
+		in       envTest
+		wantEnv  []string
+		wantFile string
+	}{
+		{
+			"normal",
+			envTest{"str", true, 1, 0.3, "e", "-", envDB{"mydb"}},
+			[]string{
+				"BOOL=true",
+				"DB_HOST=mydb",
+				"ENV_NAME=e",
+				"FLOAT64=0.3",
+				"INT=1",
+				"STRING=str",
+			},
+			"BOOL=true\nDB_HOST=mydb\nENV_NAME=e\nFLOAT64=0.3\nINT=1\nSTRING=str\n",
+		},
+		{
+			"quoted",
+			envTest{String: "a b", Rename: "x\"$y\\\n", DB: envDB{"\t"}},
+			[]string{
+				"BOOL=false",
+				"DB_HOST=\t",
+				"ENV_NAME=x\"$y\\\n",
+				"FLOAT64=0",
+				"INT=0",
+				"STRING=a b",
+			},
+			"BOOL=false\nDB_HOST=\"\\t\"\nENV_NAME=\"x\\\"\\$y\\\\\\n\"\nFLOAT64=0\nINT=0\nSTRING=\"a b\"\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			gotEnv, err := EncodeEnv(tt.in, nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(gotEnv, tt.wantEnv) {
+				t.Errorf("\nwant = %q\ngot  = %q", tt.wantEnv, gotEnv)
+			}
+			var sb strings.Builder
+			if err := WriteEnvFile(&sb, tt.in, nil); err != nil {
+				t.Error(err)
+			}
+			if gotFile := sb.String(); gotFile != tt.wantFile {
+				t.Errorf("\nwant = %q\ngot  = %q", tt.wantFile, gotFile)
+			}
+		})
+	}
+}
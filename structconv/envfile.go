@@ -0,0 +1,245 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	msgEnvFileMissingEqual    = "missing = after the key"
+	msgEnvFileInvalidKey      = "invalid key %q"
+	msgEnvFileUnterminated    = "unterminated quoted value"
+	msgEnvFileTrailingContent = "unexpected characters after the quoted value"
+)
+
+// ReadEnvFile parses the env file, also known as the dotenv file,
+// and returns the variables.
+// It supports comments, the export prefix, single-quoted values taken
+// literally, and double-quoted values with escapes. Quoted values can span
+// multiple lines. The output of WriteEnvFile can be read back.
+func ReadEnvFile(r io.Reader) (map[string]string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := envFileParser{s: string(b), line: 1}
+	m := map[string]string{}
+	for !p.eof() {
+		k, v, ok, err := p.parseLine()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m[k] = v
+		}
+	}
+	return m, nil
+}
+
+type envFileParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *envFileParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *envFileParser) peek() byte {
+	return p.s[p.pos]
+}
+
+// next returns the current byte and advances the position.
+func (p *envFileParser) next() byte {
+	c := p.s[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *envFileParser) skipSpaces() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// skipLine skips the rest of the line including the newline.
+func (p *envFileParser) skipLine() {
+	for !p.eof() {
+		if p.next() == '\n' {
+			return
+		}
+	}
+}
+
+func (p *envFileParser) errorf(line int, format string, a ...interface{}) error {
+	return &EnvFileError{Line: line, Message: fmt.Sprintf(format, a...)}
+}
+
+// parseLine parses a line, or multiple lines for a quoted value.
+// It returns false if the line has no variable.
+func (p *envFileParser) parseLine() (string, string, bool, error) {
+	p.skipSpaces()
+	if p.eof() {
+		return "", "", false, nil
+	}
+	if c := p.peek(); c == '\n' || c == '#' {
+		p.skipLine()
+		return "", "", false, nil
+	}
+
+	line := p.line
+	key := p.parseKey()
+	if key == "export" && !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.skipSpaces()
+		key = p.parseKey()
+	}
+	p.skipSpaces()
+	if p.eof() || p.peek() != '=' {
+		return "", "", false, p.errorf(line, msgEnvFileMissingEqual)
+	}
+	p.pos++
+	if !isEnvFileKey(key) {
+		return "", "", false, p.errorf(line, msgEnvFileInvalidKey, key)
+	}
+
+	start := p.pos
+	p.skipSpaces()
+	if p.eof() {
+		return key, "", true, nil
+	}
+	if p.pos > start && p.peek() == '#' {
+		// A # preceded by a space starts a comment.
+		p.skipLine()
+		return key, "", true, nil
+	}
+	var val string
+	switch p.peek() {
+	case '\'':
+		v, ok := p.parseSingleQuoted()
+		if !ok {
+			return "", "", false, p.errorf(line, msgEnvFileUnterminated)
+		}
+		val = v
+	case '"':
+		v, ok := p.parseDoubleQuoted()
+		if !ok {
+			return "", "", false, p.errorf(line, msgEnvFileUnterminated)
+		}
+		val = v
+	default:
+		return key, p.parseUnquoted(), true, nil
+	}
+
+	p.skipSpaces()
+	if !p.eof() && p.peek() != '\n' && p.peek() != '#' {
+		return "", "", false, p.errorf(p.line, msgEnvFileTrailingContent)
+	}
+	p.skipLine()
+	return key, val, true, nil
+}
+
+func (p *envFileParser) parseKey() string {
+	start := p.pos
+	for !p.eof() {
+		switch p.peek() {
+		case '=', ' ', '\t', '\r', '\n':
+			return p.s[start:p.pos]
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// parseUnquoted parses the value up to the end of the line.
+// A # preceded by a space starts a comment.
+func (p *envFileParser) parseUnquoted() string {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+	v := p.s[start:p.pos]
+	p.skipLine()
+	for i := 1; i < len(v); i++ {
+		if v[i] == '#' && (v[i-1] == ' ' || v[i-1] == '\t') {
+			v = v[:i]
+			break
+		}
+	}
+	return strings.TrimRight(v, " \t\r")
+}
+
+func (p *envFileParser) parseSingleQuoted() (string, bool) {
+	p.next()
+	start := p.pos
+	for !p.eof() {
+		if p.next() == '\'' {
+			return p.s[start : p.pos-1], true
+		}
+	}
+	return "", false
+}
+
+func (p *envFileParser) parseDoubleQuoted() (string, bool) {
+	p.next()
+	var sb strings.Builder
+	for !p.eof() {
+		c := p.next()
+		switch c {
+		case '"':
+			return sb.String(), true
+		case '\\':
+			if p.eof() {
+				return "", false
+			}
+			switch e := p.next(); e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '\\', '"', '$', '`':
+				sb.WriteByte(e)
+			case '\n':
+				// A backslash at the end of the line continues the line.
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// isEnvFileKey reports whether the key is a valid variable name.
+func isEnvFileKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '_', r == '.', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
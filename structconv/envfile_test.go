@@ -0,0 +1,133 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr *EnvFileError
+	}{
+		{
+			name: "normal",
+			in: strings.Join([]string{
+				"# comment",
+				"",
+				"A=a",
+				"export B = b ",
+				"C=c # comment",
+				"D=d#e",
+				"E=",
+				"F= # comment",
+				`G='single $x \n'`,
+				`H="double \"\n\t\\ \$ \` + "`" + `"`,
+				"I=\"multi",
+				"line\" # comment",
+				"J='multi",
+				"line'",
+				"K=k\r",
+				"L.M-N=o",
+			}, "\n"),
+			want: map[string]string{
+				"A":     "a",
+				"B":     "b",
+				"C":     "c",
+				"D":     "d#e",
+				"E":     "",
+				"F":     "",
+				"G":     `single $x \n`,
+				"H":     "double \"\n\t\\ $ `",
+				"I":     "multi\nline",
+				"J":     "multi\nline",
+				"K":     "k",
+				"L.M-N": "o",
+			},
+		},
+		{
+			name:    "missing equal",
+			in:      "A=a\nB\n",
+			wantErr: &EnvFileError{Line: 2, Message: msgEnvFileMissingEqual},
+		},
+		{
+			name:    "invalid key",
+			in:      "A=a\n\nB$=b\n",
+			wantErr: &EnvFileError{Line: 3, Message: `invalid key "B$"`},
+		},
+		{
+			name:    "unterminated",
+			in:      "A=a\nB=\"b\n\nC=c\n",
+			wantErr: &EnvFileError{Line: 2, Message: msgEnvFileUnterminated},
+		},
+		{
+			name:    "trailing content",
+			in:      "A='a\nb' c\n",
+			wantErr: &EnvFileError{Line: 2, Message: msgEnvFileTrailingContent},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ReadEnvFile(strings.NewReader(tt.in))
+			if tt.wantErr != nil {
+				var fileErr *EnvFileError
+				if !errors.As(err, &fileErr) || !reflect.DeepEqual(fileErr, tt.wantErr) {
+					t.Errorf("\nwant = %v\ngot  = %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReadEnvFileRoundTrip(t *testing.T) {
+	type envFileTest struct {
+		Plain   string
+		Empty   string
+		Special string
+		Lines   string
+		Hosts   []string
+	}
+	in := envFileTest{
+		Plain:   "a",
+		Special: "$x \"y\" `z` \\ # w",
+		Lines:   "a\nb\r\n\tc\xd3",
+		Hosts:   []string{"a", "b c"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEnvFile(&buf, &in, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadEnvFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got envFileTest
+	if err := DecodeStringMap(m, &got, &DecodeStringMapOptions{
+		TagName:      envTagName,
+		KeyConverter: func(s string) string { return strings.ToUpper(s) },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", in, got)
+	}
+}
@@ -6,11 +6,15 @@ package structconv
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 )
 
 const (
 	msgDetailRequired = "%v is required"
+	msgDetailPanic    = "unexpected panic: %v"
+	msgDetailUnused   = "%v is not used"
+	msgDetailSuggest  = "did you mean %v?"
 )
 
 // DecodeError is the decoding error information.
@@ -48,3 +52,39 @@ func (e *DecodeFieldError) Error() string {
 	}
 	return string(b)
 }
+
+// EnvFileError is the error of parsing an env file.
+type EnvFileError struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e *EnvFileError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("structconv: line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("structconv: %s:%d: %s", e.Path, e.Line, e.Message)
+}
+
+// newUnusedFieldError returns the error of the unused key,
+// suggesting the closest known key if any.
+func newUnusedFieldError(name, key, value string, known []string) *DecodeFieldError {
+	msgs := []string{fmt.Sprintf(msgDetailUnused, key)}
+	if s, ok := suggestKey(key, known); ok {
+		msgs = append(msgs, fmt.Sprintf(msgDetailSuggest, s))
+	}
+	return &DecodeFieldError{
+		Name:     name,
+		Value:    value,
+		Messages: msgs,
+	}
+}
+
+// newPanicFieldError returns the error of the field from the recovered value.
+func newPanicFieldError(name string, r interface{}) *DecodeFieldError {
+	return &DecodeFieldError{
+		Name:     name,
+		Messages: []string{fmt.Sprintf(msgDetailPanic, r)},
+	}
+}
@@ -43,6 +43,33 @@ func ExampleDecodeMap() {
 	// {AppName:myapp Port:8080 Addr: Debug:true Points:[{X:1 Y:1} {X:2 Y:2}]}
 }
 
+func ExampleEncodeMap() {
+	type point struct {
+		X int `map:"x"`
+		Y int `map:"y"`
+	}
+	type config struct {
+		AppName string
+		Port    int
+		Addr    string `map:"-"` // omitted
+		Points  []point
+	}
+	conf := config{
+		AppName: "myapp",
+		Port:    8080,
+		Addr:    ":8080",
+		Points:  []point{{X: 1, Y: 1}, {X: 2, Y: 2}},
+	}
+
+	m, err := EncodeMap(conf, nil)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("%v\n", m)
+	// Output:
+	// map[AppName:myapp Points:[map[x:1 y:1] map[x:2 y:2]] Port:8080]
+}
+
 func ExampleDecodeStringMap() {
 	type db struct {
 		Host string `strmap:"DBHost"`
@@ -75,6 +102,35 @@ func ExampleDecodeStringMap() {
 	// {AppName:myapp Port:8080 Addr: Debug:true DB:{Host:mydb Port:1234}}
 }
 
+func ExampleEncodeStringMap() {
+	type db struct {
+		Host string `strmap:"DBHost"`
+		Port int    `strmap:"DBPort"`
+	}
+	type config struct {
+		AppName string
+		Port    int    `strmap:"port"`
+		Addr    string `strmap:"-"` // omitted
+		Debug   bool
+		DB      db
+	}
+	conf := config{
+		AppName: "myapp",
+		Port:    8080,
+		Addr:    ":8080",
+		Debug:   true,
+		DB:      db{Host: "mydb", Port: 1234},
+	}
+
+	m, err := EncodeStringMap(conf, nil)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("%v\n", m)
+	// Output:
+	// map[AppName:myapp DBHost:mydb DBPort:1234 Debug:true port:8080]
+}
+
 func ExampleDecodeEnv() {
 	type db struct {
 		Host string `env:"DB_HOST"`
@@ -111,3 +167,61 @@ func ExampleDecodeEnv() {
 	// Output:
 	// {AppName:myapp Port:8080 Addr: Debug:true DB:{Host:mydb Port:1234}}
 }
+
+func ExampleEncodeEnv() {
+	type db struct {
+		Host string `env:"DB_HOST"`
+		Port int    `env:"DB_PORT"`
+	}
+	type config struct {
+		AppName string
+		Port    int
+		Addr    string `env:"-"` // omitted
+		DB      db
+	}
+	conf := config{
+		AppName: "my app",
+		Port:    8080,
+		Addr:    ":8080",
+		DB:      db{Host: "mydb", Port: 1234},
+	}
+
+	env, err := EncodeEnv(conf, nil)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("%q\n", env)
+	err = WriteEnvFile(os.Stdout, conf, nil)
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// ["APP_NAME=my app" "DB_HOST=mydb" "DB_PORT=1234" "PORT=8080"]
+	// APP_NAME="my app"
+	// DB_HOST=mydb
+	// DB_PORT=1234
+	// PORT=8080
+}
+
+func ExampleLayered() {
+	type config struct {
+		Host  string `strmap:"HOST"`
+		Port  int    `strmap:"PORT"`
+		Debug bool   `strmap:"DEBUG"`
+	}
+	src := Layered{
+		{Name: "defaults", Source: MapSource{"HOST": "localhost", "PORT": "80"}},
+		{Name: "file", Source: MapSource{"PORT": "8080"}},
+		{Name: "flags", Source: MapSource{"DEBUG": "true"}},
+	}
+
+	var conf config
+	err := DecodeSource(src, &conf, nil)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("%+v\n", conf)
+
+	// Output:
+	// {Host:localhost Port:8080 Debug:true}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	msgDetailUndefinedRef    = "${%v} is not defined"
+	msgDetailRefCycle        = "reference cycle: %v"
+	msgDetailUnterminatedRef = "unterminated reference"
+	msgDetailInvalidRef      = "invalid reference ${%v}"
+)
+
+// expander interpolates ${NAME} and ${NAME:-fallback} in the values
+// with the other values of the source.
+// $$ is replaced with $.
+type expander struct {
+	src   Source
+	cache map[string]string
+}
+
+func newExpander(src Source) *expander {
+	return &expander{src: src, cache: map[string]string{}}
+}
+
+// expandKey returns the expanded value of the key.
+// path is the keys being expanded to detect reference cycles.
+func (e *expander) expandKey(key string, path []string) (string, error) {
+	if v, ok := e.cache[key]; ok {
+		return v, nil
+	}
+	for i, k := range path {
+		if k == key {
+			cycle := append(path[i:len(path):len(path)], key)
+			return "", fmt.Errorf(msgDetailRefCycle, strings.Join(cycle, " -> "))
+		}
+	}
+	s, _ := e.src.Lookup(key)
+	v, err := e.expand(s, append(path, key))
+	if err != nil {
+		return "", err
+	}
+	e.cache[key] = v
+	return v, nil
+}
+
+// expandValue returns one of the values of the key with the references
+// expanded, for the sources that have multiple values for a key.
+// Unlike expandKey, the result is not cached.
+func (e *expander) expandValue(key, val string) (string, error) {
+	return e.expand(val, []string{key})
+}
+
+// expand returns the value with the references expanded.
+func (e *expander) expand(s string, path []string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '$' || i+1 >= len(s) {
+			sb.WriteByte(c)
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			sb.WriteByte('$')
+			i++
+		case '{':
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				return "", errors.New(msgDetailUnterminatedRef)
+			}
+			v, err := e.expandRef(s[i+2:end], path)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(v)
+			i = end
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+// expandRef expands the reference of the form NAME or NAME:-fallback.
+func (e *expander) expandRef(ref string, path []string) (string, error) {
+	name, fallback, hasFallback := ref, "", false
+	if i := strings.Index(ref, ":-"); i >= 0 {
+		name, fallback, hasFallback = ref[:i], ref[i+2:], true
+	}
+	if name == "" {
+		return "", fmt.Errorf(msgDetailInvalidRef, ref)
+	}
+	if _, ok := e.src.Lookup(name); !ok {
+		if !hasFallback {
+			return "", fmt.Errorf(msgDetailUndefinedRef, name)
+		}
+		return e.expand(fallback, path)
+	}
+	v, err := e.expandKey(name, path)
+	if err != nil {
+		return "", err
+	}
+	if v == "" && hasFallback {
+		return e.expand(fallback, path)
+	}
+	return v, nil
+}
+
+// matchingBrace returns the index of the brace closing the one at i,
+// or -1 if there is none.
+func matchingBrace(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+import (
+	"testing"
+)
+
+func TestExpander(t *testing.T) {
+	m := MapSource{
+		"USER":   "alice",
+		"HOST":   "db",
+		"EMPTY":  "",
+		"URL":    "postgres://${USER}@${HOST}/app",
+		"NESTED": "${URL}?ssl=${SSL:-off}",
+		"A":      "${B}",
+		"B":      "${C}",
+		"C":      "${A}",
+		"SELF":   "x${SELF}",
+		"UNDEF":  "${MISSING}",
+	}
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{name: "plain", in: "abc", want: "abc"},
+		{name: "reference", in: "${USER}", want: "alice"},
+		{name: "nested", in: "${NESTED}", want: "postgres://alice@db/app?ssl=off"},
+		{name: "fallback", in: "${MISSING:-a}-${EMPTY:-b}-${USER:-c}", want: "a-b-alice"},
+		{name: "fallback reference", in: "${MISSING:-${HOST}}", want: "db"},
+		{name: "empty fallback", in: "${MISSING:-}", want: ""},
+		{name: "escape", in: "$${USER} $USER $", want: "${USER} $USER $"},
+		{name: "undefined", in: "${MISSING}", wantErr: "${MISSING} is not defined"},
+		{name: "undefined in reference", in: "${UNDEF}", wantErr: "${MISSING} is not defined"},
+		{name: "cycle", in: "${A}", wantErr: "reference cycle: A -> B -> C -> A"},
+		{name: "self", in: "${SELF}", wantErr: "reference cycle: SELF -> SELF"},
+		{name: "unterminated", in: "${USER", wantErr: msgDetailUnterminatedRef},
+		{name: "invalid", in: "${:-a}", wantErr: "invalid reference ${:-a}"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := newExpander(m).expand(tt.in, nil)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("\nwant = %v\ngot  = %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("\nwant = %q\ngot  = %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -16,16 +16,20 @@ type DecodeFormOptions struct {
 	TagName      string
 	TagOnly      bool
 	KeyConverter func(string) string
+	// ErrorUnused reports the keys that are not decoded into any field.
+	ErrorUnused bool
+}
+
+type EncodeFormOptions struct {
+	TagName      string
+	TagOnly      bool
+	KeyConverter func(string) string
 }
 
 // DecodeForm decodes the form data into a struct.
+// The collection and map fields take all the values of each key
+// as the elements or the pairs. A single value is split by the separator.
 func DecodeForm(u url.Values, v interface{}, o *DecodeFormOptions) error {
-	m := map[string]string{}
-	for k, v := range u {
-		if len(v) > 0 {
-			m[k] = v[0]
-		}
-	}
 	if o == nil {
 		o = &DecodeFormOptions{}
 	}
@@ -36,6 +40,27 @@ func DecodeForm(u url.Values, v interface{}, o *DecodeFormOptions) error {
 		TagName:      o.TagName,
 		TagOnly:      o.TagOnly,
 		KeyConverter: o.KeyConverter,
+		ErrorUnused:  o.ErrorUnused,
+	}
+	return DecodeSource(ValuesSource(u), v, opts)
+}
+
+// EncodeForm encodes a struct into the form data.
+// Slice, array and map fields are encoded into multiple values,
+// which DecodeForm decodes back into the field,
+// unless a single element or pair contains the separator.
+// The keys are sorted by url.Values.Encode, so the encoded string is deterministic.
+func EncodeForm(v interface{}, o *EncodeFormOptions) (url.Values, error) {
+	if o == nil {
+		o = &EncodeFormOptions{}
+	}
+	if o.TagName == "" {
+		o.TagName = formTagName
+	}
+	opts := &EncodeStringMapOptions{
+		TagName:      o.TagName,
+		TagOnly:      o.TagOnly,
+		KeyConverter: o.KeyConverter,
 	}
-	return DecodeStringMap(m, v, opts)
+	return encodeValues(v, opts)
 }
@@ -6,6 +6,7 @@ package structconv
 
 import (
 	"net/url"
+	"reflect"
 	"testing"
 )
 
@@ -49,3 +50,103 @@ func TestDecodeForm(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeFormErrorUnused(t *testing.T) {
+	type formTestUnused struct {
+		Query string `form:"q"`
+	}
+	u, _ := url.ParseQuery("q=a&qq=b")
+	var got formTestUnused
+	err := DecodeForm(u, &got, &DecodeFormOptions{ErrorUnused: true})
+	want := []string{"qq"}
+	if got := decodeErrorNames(err); !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+}
+
+func TestEncodeForm(t *testing.T) {
+	type formTestEncode struct {
+		String  string
+		Bool    bool `form:",omitempty"`
+		Int     int
+		Float64 float64
+		Rename  string   `form:"q"`
+		Omitted string   `form:"-"`
+		Empty   string   `form:",omitempty"`
+		Slice   []string `form:"s"`
+		Array   [2]int
+		Ptr     *int `form:",omitempty"`
+	}
+
+	tests := []struct {
+		name string
+		in   formTestEncode
+		want string
+	}{
+		{
+			"normal",
+			formTestEncode{
+				String:  "a b",
+				Int:     1,
+				Float64: 0.3,
+				Rename:  "x&y",
+				Omitted: "-",
+				Slice:   []string{"c", "b", "a"},
+				Array:   [2]int{2, 3},
+			},
+			"Array=2&Array=3&Float64=0.3&Int=1&String=a+b&q=x%26y&s=c&s=b&s=a",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			u, err := EncodeForm(tt.in, nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if got := u.Encode(); got != tt.want {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFormRoundTrip(t *testing.T) {
+	type formTestRoundTrip struct {
+		IDs    []int
+		Names  []string `form:"name"`
+		Array  [3]int
+		Ptrs   []*int
+		Labels map[string]string
+		Commas []string `form:"tags"`
+		Single []string
+		Empty  []string
+	}
+	one := 1
+	in := formTestRoundTrip{
+		IDs:    []int{1, 2, 3},
+		Names:  []string{"a", "", "b c"},
+		Array:  [3]int{4, 5},
+		Ptrs:   []*int{&one},
+		Labels: map[string]string{"a": "1", "b": "2", "k": "v,w"},
+		Commas: []string{"a,b", "c"},
+		Single: []string{"x"},
+	}
+
+	u, err := EncodeForm(in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = url.ParseQuery(u.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got formTestRoundTrip
+	if err := DecodeForm(u, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", in, got)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package structconv
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fuzzNested struct {
+	Host  string `map:"host" strmap:"HOST" form:"host" queryparam:"host"`
+	Port  uint16 `map:"port,required" strmap:"PORT" form:"port" queryparam:"port"`
+	Ptr   *fuzzNested
+	Items [][]*fuzzNested
+}
+
+type fuzzTarget struct {
+	Int      int8              `map:"int" strmap:"INT,default=1" form:"int" queryparam:"int"`
+	Uint     uint              `map:"uint,conv" strmap:"UINT" form:"uint" queryparam:"uint"`
+	Float    float32           `map:"float,conv" strmap:"FLOAT" form:"float" queryparam:"float"`
+	Bool     *bool             `map:"bool" strmap:"BOOL" form:"bool" queryparam:"bool"`
+	String   string            `map:"string,conv" strmap:"STRING" form:"string" queryparam:"string"`
+	Duration time.Duration     `map:"duration" strmap:"DURATION" form:"duration" queryparam:"duration"`
+	Time     time.Time         `map:"time" strmap:"TIME,layout=2006-01-02" form:"time" queryparam:"time"`
+	IP       net.IP            `map:"ip" strmap:"IP" form:"ip" queryparam:"ip"`
+	Ints     []int             `map:"ints" strmap:"INTS,sep=;" form:"ints" queryparam:"ints"`
+	Array    [2]*int           `map:"array,conv" strmap:"ARRAY" form:"array" queryparam:"array"`
+	Labels   map[string]uint8  `map:"labels" strmap:"LABELS" form:"labels" queryparam:"labels"`
+	Any      interface{}       `map:"any" strmap:"ANY" form:"any" queryparam:"any"`
+	Nested   fuzzNested        `map:"nested"`
+	Nests    []fuzzNested      `map:"nests"`
+	Fixed    [1][2]*fuzzNested `map:"fixed"`
+}
+
+// checkFuzzError fails if the decoder recovered from a panic,
+// which the decoders report as an error instead of crashing.
+func checkFuzzError(t *testing.T, err error) {
+	t.Helper()
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) {
+		return
+	}
+	panicMsg := strings.TrimSuffix(msgDetailPanic, "%v")
+	for _, e := range decErr.Detail {
+		for _, msg := range e.Messages {
+			if strings.HasPrefix(msg, panicMsg) {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func FuzzDecodeMap(f *testing.F) {
+	f.Add([]byte(`{"int":1,"uint":2.5,"float":"x","bool":true,"string":3}`))
+	f.Add([]byte(`{"nested":{"host":"h","port":1,"Ptr":{"Items":[[{"port":2},null]]}}}`))
+	f.Add([]byte(`{"nests":[{"port":1},{}],"fixed":[[{"port":1},{"port":2},{"port":3}]]}`))
+	f.Add([]byte(`{"ints":[1,"2"],"array":[1,2,3],"labels":{"a":1},"any":[]}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return
+		}
+		for _, o := range []*DecodeMapOptions{
+			nil,
+			{WeaklyTyped: true},
+			{IgnoreInvalidType: true},
+		} {
+			var v fuzzTarget
+			checkFuzzError(t, DecodeMap(m, &v, o))
+		}
+	})
+}
+
+func FuzzDecodeStringMap(f *testing.F) {
+	f.Add("INT", "127", "INTS", "1;2;x")
+	f.Add("TIME", "2020-01-02", "LABELS", "a=1,b")
+	f.Add("ARRAY", "1,2,3", "PORT", "65536")
+	f.Fuzz(func(t *testing.T, k1, v1, k2, v2 string) {
+		m := map[string]string{k1: v1, k2: v2}
+		var v fuzzTarget
+		checkFuzzError(t, DecodeStringMap(m, &v, nil))
+	})
+}
+
+func FuzzDecodeQueryParam(f *testing.F) {
+	f.Add("int=1&ints=1;2&labels=a%3D1&host=h&port=1")
+	f.Add("array=1,2,3&ip=::1&duration=1h&bool=maybe")
+	f.Fuzz(func(t *testing.T, q string) {
+		u, err := url.ParseQuery(q)
+		if err != nil {
+			return
+		}
+		var v fuzzTarget
+		checkFuzzError(t, DecodeQueryParam(u, &v, nil))
+		checkFuzzError(t, DecodeForm(u, &v, nil))
+	})
+}
+
+func FuzzDecodeEnv(f *testing.F) {
+	f.Add("INT=1\nINTS=1;2\nFLOAT=1e40")
+	f.Add("INT=${A:-${B}}\nA=$${INT}\nB=${INT}")
+	f.Add("APP_INT=${APP_UINT}\nAPP_UINT=-1\nAPP_LABELS=a=1,b")
+	f.Fuzz(func(t *testing.T, s string) {
+		env := os.Environ()
+		defer func() {
+			os.Clearenv()
+			for _, e := range env {
+				if kv := strings.SplitN(e, "=", 2); len(kv) == 2 {
+					os.Setenv(kv[0], kv[1])
+				}
+			}
+		}()
+
+		os.Clearenv()
+		for _, line := range strings.Split(s, "\n") {
+			if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+				// Invalid names and values are rejected by os.Setenv.
+				_ = os.Setenv(kv[0], kv[1])
+			}
+		}
+		// The files named by the input are not read.
+		for _, o := range []*DecodeEnvOptions{
+			{DisableFileSuffix: true},
+			{DisableFileSuffix: true, Expand: true},
+			{DisableFileSuffix: true, Prefix: "APP_", Expand: true},
+		} {
+			var v fuzzTarget
+			checkFuzzError(t, DecodeEnv(&v, o))
+		}
+	})
+}
+
+func FuzzReadEnvFile(f *testing.F) {
+	f.Add("# comment\nexport A=a # b\nB='c\nd'\nC=\"e\\n\\\"f\"\n")
+	f.Fuzz(func(t *testing.T, s string) {
+		m, err := ReadEnvFile(strings.NewReader(s))
+		if err != nil {
+			return
+		}
+		// The variables written by WriteEnvFile must be read back as is.
+		var sb strings.Builder
+		for _, k := range sortedKeys(m) {
+			sb.WriteString(k + "=" + quoteEnvValue(m[k]) + "\n")
+		}
+		again, err := ReadEnvFile(strings.NewReader(sb.String()))
+		if err != nil {
+			t.Fatalf("%q: %v", sb.String(), err)
+		}
+		for k, v := range m {
+			if again[k] != v {
+				t.Fatalf("%q: want %q, got %q", k, v, again[k])
+			}
+		}
+	})
+}
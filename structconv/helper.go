@@ -8,11 +8,19 @@ import (
 	"errors"
 	"reflect"
 	"strings"
+	"sync"
 )
 
 var (
-	requiredTagValue = "required"
-	convTagValue     = "conv"
+	requiredTagValue  = "required"
+	convTagValue      = "conv"
+	omitEmptyTagValue = "omitempty"
+	layoutTagValue    = "layout"
+	sepTagValue       = "sep"
+	kvSepTagValue     = "kvsep"
+	defaultTagValue   = "default"
+	prefixTagValue    = "prefix"
+	fileTagValue      = "file"
 )
 
 type fieldInfo struct {
@@ -21,14 +29,48 @@ type fieldInfo struct {
 	Child       reflect.Value
 	ChildOK     bool
 	Collections []reflect.Type
+	Unmarshaler bool
+	Marshaler   bool
+	Tag         decodeTagInfo
+	TagErr      error
+}
+
+// structField is the metadata of a struct field that does not depend on
+// the struct value.
+type structField struct {
+	Index       int
+	Meta        reflect.StructField
+	Collections []reflect.Type
+	Unmarshaler bool
+	Marshaler   bool
+	Tag         decodeTagInfo
+	TagErr      error
+}
+
+// fieldCache is a concurrency-safe cache of struct fields.
+type fieldCache struct {
+	m sync.Map // map[fieldCacheKey][]structField
+}
+
+type fieldCacheKey struct {
+	Type    reflect.Type
+	TagName string
 }
 
 type decodeTagInfo struct {
-	OK       bool
-	Key      string
-	Required bool
-	Omitted  bool
-	Conv     bool
+	OK         bool
+	Key        string
+	Required   bool
+	Omitted    bool
+	Conv       bool
+	OmitEmpty  bool
+	Layout     string
+	Sep        string
+	KVSep      string
+	Default    string
+	HasDefault bool
+	Prefix     string
+	File       bool
 }
 
 // checkStructPtr checks the struct pointer.
@@ -47,33 +89,114 @@ func checkStructPtr(structPtr interface{}) (reflect.Value, error) {
 	return sv, nil
 }
 
-// walkStructFields walks the structure tree, calling walkFn for each field
-// in the tree, including root.
-func walkStructFields(s reflect.Value, walkFn func(fieldInfo)) {
-	sv := s
-	st := sv.Type()
-	for i := 0; i < sv.NumField(); i++ {
-		fv := sv.Field(i)
-		fm := st.Field(i)
+// checkStruct checks the struct or the struct pointer,
+// and returns the addressable struct.
+func checkStruct(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		err := errors.New("structconv: v must be a struct or a struct pointer")
+		return rv, err
+	}
+	if !rv.CanAddr() {
+		pv := reflect.New(rv.Type())
+		pv.Elem().Set(rv)
+		rv = pv.Elem()
+	}
+	return rv, nil
+}
 
-		if !fv.CanSet() {
-			continue
+// hasExportedFields reports whether the struct type has any exported field.
+// Structs without exported fields, such as time.Time, are treated as values.
+func hasExportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return true
 		}
+	}
+	return false
+}
 
+// walkStructFields walks the structure tree, calling walkFn for each field
+// in the tree, including root.
+// The field metadata is cached in c unless c is nil.
+func walkStructFields(c *fieldCache, s reflect.Value, tagName string, walkFn func(fieldInfo)) {
+	for _, f := range c.fields(s.Type(), tagName) {
+		fv := s.Field(f.Index)
 		child, ok := followStruct(fv, false)
-		collections := followStructCollectionsTypes(fv)
 
 		fi := fieldInfo{
-			Meta:        fm,
+			Meta:        f.Meta,
 			Value:       fv,
 			Child:       child,
 			ChildOK:     ok,
-			Collections: collections,
+			Collections: f.Collections,
+			Unmarshaler: f.Unmarshaler,
+			Marshaler:   f.Marshaler,
+			Tag:         f.Tag,
+			TagErr:      f.TagErr,
 		}
 		walkFn(fi)
 	}
 }
 
+// fields returns the settable fields of the struct type.
+func (c *fieldCache) fields(t reflect.Type, tagName string) []structField {
+	if c == nil {
+		return typeFields(t, tagName)
+	}
+	key := fieldCacheKey{Type: t, TagName: tagName}
+	if f, ok := c.m.Load(key); ok {
+		return f.([]structField)
+	}
+	f, _ := c.m.LoadOrStore(key, typeFields(t, tagName))
+	return f.([]structField)
+}
+
+func typeFields(t reflect.Type, tagName string) []structField {
+	var result []structField
+	for i := 0; i < t.NumField(); i++ {
+		fm := t.Field(i)
+		if fm.PkgPath != "" {
+			// Unexported fields are not settable.
+			continue
+		}
+		tag, err := parseDecodeTag(fm, tagName)
+		f := structField{
+			Index:       i,
+			Meta:        fm,
+			Collections: followStructCollectionsTypes(fm.Type),
+			Unmarshaler: isUnmarshalerType(fm.Type),
+			Marshaler:   isMarshalerType(fm.Type),
+			Tag:         tag,
+			TagErr:      err,
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// isUnmarshalerType reports whether the type, following pointers,
+// can be decoded from a string by an unmarshaler.
+func isUnmarshalerType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	pt := reflect.PtrTo(t)
+	return pt.Implements(stringUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+// isMarshalerType reports whether the type, following pointers,
+// can be encoded into a string by a marshaler.
+func isMarshalerType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return reflect.PtrTo(t).Implements(textMarshalerType)
+}
+
 func followStruct(fv reflect.Value, init bool) (reflect.Value, bool) {
 	// Follow the pointer.
 	v := fv
@@ -98,9 +221,8 @@ func followStruct(fv reflect.Value, init bool) (reflect.Value, bool) {
 	return v, true
 }
 
-func followStructCollectionsTypes(rv reflect.Value) []reflect.Type {
+func followStructCollectionsTypes(rt reflect.Type) []reflect.Type {
 	var collections []reflect.Type
-	rt := rv.Type()
 	for {
 		switch rt.Kind() {
 		case reflect.Slice, reflect.Array:
@@ -124,18 +246,33 @@ func initStruct(structPtr interface{}) error {
 	if err != nil {
 		return err
 	}
-	doInitStruct(sv)
+	doInitStruct(sv, map[reflect.Type]bool{})
 	return nil
 }
 
-func doInitStruct(sv reflect.Value) {
+// doInitStruct initializes the struct pointers in the struct tree.
+// Recursive struct types are not initialized to avoid infinite recursion.
+func doInitStruct(sv reflect.Value, path map[reflect.Type]bool) {
+	path[sv.Type()] = true
+	defer delete(path, sv.Type())
+
 	for i := 0; i < sv.NumField(); i++ {
 		fv := sv.Field(i)
 		if !fv.CanSet() {
 			continue
 		}
+		if isUnmarshalerType(fv.Type()) {
+			continue
+		}
+		t := fv.Type()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if path[t] {
+			continue
+		}
 		if cv, ok := followStruct(fv, true); ok {
-			doInitStruct(cv)
+			doInitStruct(cv, path)
 		}
 	}
 }
@@ -150,6 +287,7 @@ func parseDecodeTag(f reflect.StructField, tagName string) (decodeTagInfo, error
 	result.OK = true
 
 	tags := strings.Split(tagStr, ",")
+	var continued *string
 	for i, v := range tags {
 		if i == 0 {
 			if v == "-" {
@@ -159,12 +297,110 @@ func parseDecodeTag(f reflect.StructField, tagName string) (decodeTagInfo, error
 			}
 			continue
 		}
-		switch v {
+		name, value := v, ""
+		if i := strings.Index(v, "="); i >= 0 {
+			name, value = v[:i], v[i+1:]
+		}
+		// The layout and the default value may contain commas,
+		// such as "Jan 2, 2006" or the elements of a slice,
+		// so they continue up to the next option.
+		if continued != nil && !isTagOption(name) {
+			*continued += "," + v
+			continue
+		}
+		continued = nil
+		switch name {
 		case requiredTagValue:
 			result.Required = true
 		case convTagValue:
 			result.Conv = true
+		case omitEmptyTagValue:
+			result.OmitEmpty = true
+		case layoutTagValue:
+			result.Layout = value
+			continued = &result.Layout
+		case sepTagValue:
+			result.Sep = value
+		case kvSepTagValue:
+			result.KVSep = value
+		case defaultTagValue:
+			result.Default = value
+			result.HasDefault = true
+			continued = &result.Default
+		case prefixTagValue:
+			result.Prefix = value
+		case fileTagValue:
+			result.File = true
 		}
 	}
 	return result, nil
 }
+
+// isTagOption reports whether the name is a tag option.
+func isTagOption(name string) bool {
+	switch name {
+	case requiredTagValue, convTagValue, omitEmptyTagValue, layoutTagValue,
+		sepTagValue, kvSepTagValue, defaultTagValue, prefixTagValue, fileTagValue:
+		return true
+	}
+	return false
+}
+
+// isEmptyValue reports whether the value is empty in the sense of
+// the omitempty tag option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// maxSuggestDistance is the maximum edit distance of the suggested keys.
+const maxSuggestDistance = 2
+
+// suggestKey returns the known key closest to the key in edit distance.
+func suggestKey(key string, known []string) (string, bool) {
+	var result string
+	min := maxSuggestDistance + 1
+	for _, k := range known {
+		d := levenshtein(strings.ToLower(key), strings.ToLower(k))
+		if d < min && d < len(key) {
+			result, min = k, d
+		}
+	}
+	return result, result != ""
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a int, b ...int) int {
+	for _, v := range b {
+		if v < a {
+			a = v
+		}
+	}
+	return a
+}
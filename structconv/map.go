@@ -7,27 +7,67 @@ package structconv
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
 )
 
 const (
 	msgDetailInvalidType = "invalid type: in=%v, out=%v"
+	msgDetailOverflow    = "%v overflows %v"
+	msgDetailFraction    = "%v loses its fractional part in %v"
+	msgDetailNegative    = "%v is negative for %v"
 	mapTagName           = "map"
 )
 
 type DecodeMapOptions struct {
 	TagName string
 	TagOnly bool
+	// IgnoreInvalidType leaves the fields unchanged instead of reporting
+	// errors when the value types do not match the field types.
+	IgnoreInvalidType bool
+	// WeaklyTyped converts the values whose types do not match the field
+	// types, such as float64 into int, "true" into bool and 1 into string.
+	WeaklyTyped bool
+	// ErrorUnused reports the keys of the maps that are not decoded
+	// into any field, including the maps of the nested structs.
+	ErrorUnused bool
+	// Metadata, if not nil, receives the information about the decoding.
+	// It is ignored by Decoder.
+	// The fields in the collection and map elements are not recorded,
+	// except for the unused keys.
+	Metadata *Metadata
 }
 
+type EncodeMapOptions struct {
+	TagName string
+	TagOnly bool
+}
+
+var mapInterfaceType = reflect.TypeOf(map[string]interface{}{})
+
 // DecodeMap decodes a map into a struct.
 func DecodeMap(m map[string]interface{}, v interface{}, o *DecodeMapOptions) error {
 	o = initDecodeMapOptions(o)
+	return decodeMap(m, v, *o, nil)
+}
+
+func decodeMap(m map[string]interface{}, v interface{}, o DecodeMapOptions, c *fieldCache) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &DecodeError{
+				Detail: []*DecodeFieldError{newPanicFieldError("map", r)},
+			}
+		}
+	}()
+
 	s, err := checkStructPtr(v)
 	if err != nil {
 		return err
 	}
-	if decErrs := mapToStruct("map", m, s, *o); len(decErrs) > 0 {
+	o.Metadata.reset()
+	p := mapToStructParams{Options: o, Cache: c}
+	if decErrs := mapToStruct("map", m, s, p); len(decErrs) > 0 {
 		return &DecodeError{
 			Detail: decErrs,
 		}
@@ -48,26 +88,56 @@ func initDecodeMapOptions(o *DecodeMapOptions) *DecodeMapOptions {
 	return o
 }
 
-func mapToStruct(name string, m interface{}, s reflect.Value, o DecodeMapOptions) []*DecodeFieldError {
+type mapToStructParams struct {
+	Options   DecodeMapOptions
+	Cache     *fieldCache
+	InElement bool
+}
+
+// fieldMetadata returns the metadata to record the fields in.
+// The fields in the collection and map elements are not recorded.
+func (p mapToStructParams) fieldMetadata() *Metadata {
+	if p.InElement {
+		return nil
+	}
+	return p.Options.Metadata
+}
+
+func mapToStruct(name string, m interface{}, s reflect.Value, p mapToStructParams) []*DecodeFieldError {
 	rv := reflect.ValueOf(m)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return []*DecodeFieldError{{
+			Name:     name,
+			Value:    fmt.Sprintf("%v", m),
+			Messages: []string{fmt.Sprintf(msgDetailInvalidType, reflect.TypeOf(m), s.Type())},
+		}}
+	}
 	var decErrs []*DecodeFieldError
+	var known []string
 
-	walkStructFields(s, func(f fieldInfo) {
+	walkStructFields(p.Cache, s, p.Options.TagName, func(f fieldInfo) {
 		fm := f.Meta
 		fk := fm.Name
 
-		tag, err := parseDecodeTag(fm, o.TagName)
-		if err != nil {
+		fieldName := name + "[" + fk + "]"
+		defer func() {
+			if r := recover(); r != nil {
+				decErrs = append(decErrs, newPanicFieldError(fieldName, r))
+			}
+		}()
+
+		tag := f.Tag
+		if f.TagErr != nil {
 			decErr := &DecodeFieldError{
 				Name: name + "[" + fk + "]",
 				Messages: []string{
-					err.Error(),
+					f.TagErr.Error(),
 				},
 			}
 			decErrs = append(decErrs, decErr)
 			return
 		}
-		if o.TagOnly && !f.ChildOK && !tag.OK {
+		if p.Options.TagOnly && !f.ChildOK && !tag.OK {
 			return
 		}
 		if tag.Omitted {
@@ -81,237 +151,599 @@ func mapToStruct(name string, m interface{}, s reflect.Value, o DecodeMapOptions
 			mapKeyStr = fk
 		}
 		newName := name + "[" + mapKeyStr + "]"
+		fieldName = newName
+		known = append(known, mapKeyStr)
 
-		mv := rv.MapIndex(reflect.ValueOf(mapKeyStr))
+		mv := rv.MapIndex(reflect.ValueOf(mapKeyStr).Convert(rv.Type().Key()))
 		if !mv.IsValid() {
-			if tag.Required {
+			switch {
+			case tag.HasDefault:
+				if e := convertDefaultToField(newName, f.Value, tag); len(e) > 0 {
+					decErrs = append(decErrs, e...)
+				} else {
+					p.fieldMetadata().addDefaulted(newName)
+				}
+			case tag.Required:
 				decErr := &DecodeFieldError{
 					Name:     newName,
 					Messages: []string{fmt.Sprintf(msgDetailRequired, fk)},
 				}
 				decErrs = append(decErrs, decErr)
+				p.fieldMetadata().addMissing(newName)
+			case f.ChildOK:
+				if e := defaultsToStruct(newName, f.Child, p); len(e) > 0 {
+					decErrs = append(decErrs, e...)
+				}
+			default:
+				p.fieldMetadata().addUnset(newName)
 			}
 			return
 		}
 
-		doMapToStruct(newName, mv, f, tag, o)
+		if e := doMapToStruct(newName, mv, f, tag, p); len(e) > 0 {
+			decErrs = append(decErrs, e...)
+		}
 	})
 
+	if p.Options.ErrorUnused || p.Options.Metadata != nil {
+		for _, k := range unusedMapKeys(rv, known) {
+			newName := name + "[" + k + "]"
+			p.Options.Metadata.addUnused(newName)
+			if !p.Options.ErrorUnused {
+				continue
+			}
+			v := rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()))
+			value := fmt.Sprintf("%v", v.Interface())
+			decErrs = append(decErrs, newUnusedFieldError(newName, k, value, known))
+		}
+	}
+	return decErrs
+}
+
+// unusedMapKeys returns the sorted keys of the map that are not in known.
+func unusedMapKeys(rv reflect.Value, known []string) []string {
+	used := make(map[string]bool, len(known))
+	for _, k := range known {
+		used[k] = true
+	}
+	var keys []string
+	for _, k := range rv.MapKeys() {
+		if !used[k.String()] {
+			keys = append(keys, k.String())
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// defaultsToStruct sets the default values of the tags
+// to the fields of the struct missing from the map.
+func defaultsToStruct(name string, s reflect.Value, p mapToStructParams) []*DecodeFieldError {
+	var decErrs []*DecodeFieldError
+	walkStructFields(p.Cache, s, p.Options.TagName, func(f fieldInfo) {
+		tag := f.Tag
+		if f.TagErr != nil || tag.Omitted {
+			return
+		}
+		if p.Options.TagOnly && !f.ChildOK && !tag.OK {
+			return
+		}
+		key := f.Meta.Name
+		if tag.OK && tag.Key != "" {
+			key = tag.Key
+		}
+		newName := name + "[" + key + "]"
+
+		switch {
+		case tag.HasDefault:
+			if e := convertDefaultToField(newName, f.Value, tag); len(e) > 0 {
+				decErrs = append(decErrs, e...)
+			} else {
+				p.fieldMetadata().addDefaulted(newName)
+			}
+		case f.ChildOK:
+			if e := defaultsToStruct(newName, f.Child, p); len(e) > 0 {
+				decErrs = append(decErrs, e...)
+			}
+		default:
+			p.fieldMetadata().addUnset(newName)
+		}
+	})
 	return decErrs
 }
 
-func doMapToStruct(name string, mv reflect.Value, fi fieldInfo, tag decodeTagInfo, o DecodeMapOptions) []*DecodeFieldError {
+func doMapToStruct(name string, mv reflect.Value, fi fieldInfo, tag decodeTagInfo, p mapToStructParams) []*DecodeFieldError {
 	if isNil(mv) {
+		p.fieldMetadata().addSet(name)
 		return nil
 	}
 	if mv.Type().Kind() == reflect.Interface {
 		mv = mv.Elem()
 	}
 	if tag.Conv {
-		mv = mv.Convert(fi.Meta.Type)
+		cv, err := convertValue(mv, fi.Meta.Type)
+		if err != nil {
+			return []*DecodeFieldError{{
+				Name:     name,
+				Value:    fmt.Sprintf("%v", mv.Interface()),
+				Messages: []string{err.Error()},
+			}}
+		}
+		mv = cv
 	}
 
 	switch mv.Type().Kind() {
 	case reflect.Map:
-		if !fi.ChildOK {
-			setReflectValue(fi.Value, mv)
-			break
+		child, ok := fi.Child, fi.ChildOK
+		if !ok && mv.Type().Key().Kind() == reflect.String {
+			// Initialize the nil struct pointer to decode the map into it.
+			child, ok = followStruct(fi.Value, true)
 		}
-		if e := mapToStruct(name, mv.Interface(), fi.Child, o); len(e) > 0 {
-			return e
+		if !ok {
+			return decodeMapField(name, fi.Value, mv, tag, p)
 		}
-	case reflect.Array, reflect.Slice:
-		if len(fi.Collections) == 0 {
-			setReflectValue(fi.Value, mv)
-			break
-		}
-		if e := checkCollections(name, mv, fi.Collections); e != nil {
-			return e
-		}
-		cv, e := makeCollections(name, mv, fi.Collections, o)
-		if len(e) > 0 {
+		if e := mapToStruct(name, mv.Interface(), child, p); len(e) > 0 {
 			return e
 		}
-		fi.Value.Set(cv)
 	default:
-		setReflectValue(fi.Value, mv)
+		return decodeMapField(name, fi.Value, mv, tag, p)
 	}
 	return nil
 }
 
-func setReflectValue(dst, src reflect.Value) {
-	if src.Type() == dst.Type() ||
-		dst.Type().Kind() == reflect.Interface &&
-			src.Type().Implements(dst.Type()) {
-		dst.Set(src)
+// decodeMapField decodes src into the field,
+// and records the field as set if it succeeds.
+func decodeMapField(name string, dst, src reflect.Value, tag decodeTagInfo, p mapToStructParams) []*DecodeFieldError {
+	if e := decodeMapValue(name, dst, src, tag, p); len(e) > 0 {
+		return e
 	}
-	if dst.Type().Kind() == reflect.Ptr && src.Type().Kind() != reflect.Ptr {
-		dstET := dst.Type().Elem()
-		eqType := src.Type() == dstET
-		impl := dstET.Kind() == reflect.Interface && src.Type().Implements(dstET)
-		if (eqType || impl) && !isNil(src) {
-			rv := reflect.New(dstET)
-			rv.Elem().Set(src)
-			dst.Set(rv)
-			// dst.Set(src.Addr())
+	p.fieldMetadata().addSet(name)
+	return nil
+}
+
+// decodeMapValue decodes src into dst.
+// Slices, arrays and maps are decoded element by element,
+// and maps into structs in the elements.
+func decodeMapValue(name string, dst, src reflect.Value, tag decodeTagInfo, p mapToStructParams) []*DecodeFieldError {
+	if src.Kind() == reflect.Interface && !src.IsNil() {
+		src = src.Elem()
+	}
+	if isNil(src) {
+		return nil
+	}
+	if setReflectValue(dst, src) {
+		return nil
+	}
+
+	v := reflect.New(dst.Type()).Elem()
+	cv := allocPtr(v)
+	var errs []*DecodeFieldError
+	switch {
+	case cv.Kind() == reflect.Struct && src.Kind() == reflect.Map:
+		errs = mapToStruct(name, src.Interface(), cv, p)
+	case isCollectionKind(cv.Kind()) && isCollectionKind(src.Kind()):
+		errs = decodeMapCollection(name, cv, src, tag, p)
+	case cv.Kind() == reflect.Map && src.Kind() == reflect.Map:
+		errs = decodeMapMap(name, cv, src, tag, p)
+	default:
+		return setFieldValue(name, dst, src, tag, p)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	dst.Set(v)
+	return nil
+}
+
+func decodeMapCollection(name string, dst, src reflect.Value, tag decodeTagInfo, p mapToStructParams) []*DecodeFieldError {
+	p.InElement = true
+	switch dst.Kind() {
+	case reflect.Array:
+		if src.Len() > dst.Len() {
+			return []*DecodeFieldError{{
+				Name:  name,
+				Value: fmt.Sprintf("%v", src.Interface()),
+				Messages: []string{
+					fmt.Sprintf(msgDetailInvalidType, src.Type(), dst.Type()),
+					fmt.Sprintf(msgDetailTooManyElements, name, dst.Len()),
+				},
+			}}
+		}
+	case reflect.Slice:
+		dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+	}
+
+	var errs []*DecodeFieldError
+	for i := 0; i < src.Len(); i++ {
+		newName := name + "[" + fmt.Sprint(i) + "]"
+		if e := decodeMapValue(newName, dst.Index(i), src.Index(i), tag, p); len(e) > 0 {
+			errs = append(errs, e...)
 		}
 	}
+	return errs
 }
 
-func checkCollections(name string, in reflect.Value, out []reflect.Type) []*DecodeFieldError {
-	if len(out) == 0 {
+func decodeMapMap(name string, dst, src reflect.Value, tag decodeTagInfo, p mapToStructParams) []*DecodeFieldError {
+	p.InElement = true
+	m := reflect.MakeMapWithSize(dst.Type(), src.Len())
+
+	// Sort the keys so that the errors are reported in a stable order.
+	keys := src.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	var errs []*DecodeFieldError
+	for _, sk := range keys {
+		newName := name + "[" + fmt.Sprint(sk.Interface()) + "]"
+		k := reflect.New(dst.Type().Key()).Elem()
+		if e := decodeMapKey(newName, k, sk, tag, p); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+		v := reflect.New(dst.Type().Elem()).Elem()
+		if e := decodeMapValue(newName, v, src.MapIndex(sk), tag, p); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+		m.SetMapIndex(k, v)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	dst.Set(m)
+	return nil
+}
+
+// decodeMapKey decodes the map key src into dst.
+// String keys are parsed into the key types supported by
+// the string-based decoders, such as ints and TextUnmarshalers.
+func decodeMapKey(name string, dst, src reflect.Value, tag decodeTagInfo, p mapToStructParams) []*DecodeFieldError {
+	if src.Kind() == reflect.Interface && !src.IsNil() {
+		src = src.Elem()
+	}
+	if setReflectValue(dst, src) {
 		return nil
 	}
-	var decErrs []*DecodeFieldError
+	if src.Kind() == reflect.String && isStringConvertibleType(dst.Type(), false) {
+		return convertStringToScalar(name, allocPtr(dst), src.String(), tag)
+	}
+	return setFieldValue(name, dst, src, tag, p)
+}
 
-	if in.Kind() != out[0].Kind() {
-		msg := fmt.Sprintf(msgDetailInvalidType, in.Type(), out[0])
-		decErr := &DecodeFieldError{
-			Name:     name,
-			Messages: []string{msg},
-		}
-		return append(decErrs, decErr)
+func isCollectionKind(k reflect.Kind) bool {
+	return k == reflect.Array || k == reflect.Slice
+}
+
+// setFieldValue sets src to the field,
+// and reports an error if the types do not match.
+func setFieldValue(name string, dst, src reflect.Value, tag decodeTagInfo, p mapToStructParams) []*DecodeFieldError {
+	if setReflectValue(dst, src) {
+		return nil
 	}
-	if in.Kind() == reflect.Array && in.Type().Len() != out[0].Len() {
-		msg := fmt.Sprintf(msgDetailInvalidType, in.Type(), out[0])
-		decErr := &DecodeFieldError{
-			Name:     name,
-			Messages: []string{msg},
+	var err error
+	if p.Options.WeaklyTyped {
+		var ok bool
+		if ok, err = weakConvertValue(dst, src, tag); ok && err == nil {
+			return nil
 		}
-		return append(decErrs, decErr)
 	}
-	if len(out) == 1 {
+	if err == nil && p.Options.IgnoreInvalidType {
 		return nil
 	}
+	msgs := []string{fmt.Sprintf(msgDetailInvalidType, src.Type(), dst.Type())}
+	if err != nil {
+		msgs = append(msgs, err.Error())
+	}
+	return []*DecodeFieldError{{
+		Name:     name,
+		Value:    fmt.Sprintf("%v", src.Interface()),
+		Messages: msgs,
+	}}
+}
+
+// weakConvertValue converts src into the type of dst and sets it to dst.
+// It reports whether the conversion between the types is supported.
+// dst is left unchanged if the conversion fails.
+func weakConvertValue(dst, src reflect.Value, tag decodeTagInfo) (bool, error) {
+	v := reflect.New(dst.Type()).Elem()
+	cv := allocPtr(v)
+	if !isStringConvertibleType(cv.Type(), false) {
+		return false, nil
+	}
 
-	for i := 0; i < in.Len(); i++ {
-		newName := name + "[" + fmt.Sprint(i) + "]"
-		if e := checkCollections(newName, in.Index(i), out[1:]); len(e) > 0 {
-			decErrs = append(decErrs, e...)
+	var err error
+	switch {
+	case src.Kind() == reflect.String:
+		err = doConvertStringToField(cv, src.String(), tag)
+	case cv.Kind() == reflect.String:
+		s, ok, e := doConvertFieldToString(src, tag)
+		if !ok {
+			return false, e
 		}
+		cv.SetString(s)
+	case isNumberKind(src.Kind()) && isNumberKind(cv.Kind()):
+		err = convertNumber(cv, src, false)
+	default:
+		return false, nil
 	}
-	return decErrs
+	if err != nil {
+		return true, err
+	}
+	dst.Set(v)
+	return true, nil
 }
 
-func makeCollections(name string, in reflect.Value, out []reflect.Type, o DecodeMapOptions) (reflect.Value, []*DecodeFieldError) {
-	if len(out) == 0 {
-		var v reflect.Value
-		return v, []*DecodeFieldError{{
-			Name:     name,
-			Messages: []string{"internal error: out is empty"},
-		}}
+// convertValue converts the value to the type like reflect.Value.Convert,
+// but reports an error instead of panicking, or on numeric overflow.
+func convertValue(v reflect.Value, t reflect.Type) (reflect.Value, error) {
+	if !v.Type().ConvertibleTo(t) {
+		return v, fmt.Errorf(msgDetailInvalidType, v.Type(), t)
+	}
+	if isNumberKind(v.Kind()) && isNumberKind(t.Kind()) {
+		result := reflect.New(t).Elem()
+		if err := convertNumber(result, v, true); err != nil {
+			return v, err
+		}
+		return result, nil
 	}
-	if isNil(in) {
-		return reflect.Zero(out[0]), nil
+	if v.Kind() == reflect.Slice {
+		// Converting a slice to an array or an array pointer panics
+		// if the slice is shorter than the array.
+		at := t
+		if at.Kind() == reflect.Ptr {
+			at = at.Elem()
+		}
+		if at.Kind() == reflect.Array && v.Len() < at.Len() {
+			return v, fmt.Errorf(msgDetailInvalidType, v.Type(), t)
+		}
 	}
+	return v.Convert(t), nil
+}
 
-	var result reflect.Value
-	var decErrs []*DecodeFieldError
-	switch in.Type().Kind() {
-	case reflect.Array:
-		if len(out) > 1 {
-			result = reflect.New(out[0]).Elem()
-			for i := 0; i < in.Len(); i++ {
-				newName := name + "[" + fmt.Sprint(i) + "]"
-				v, e := makeCollections(newName, in.Index(i), out[1:], o)
-				if len(e) > 0 {
-					decErrs = append(decErrs, e...)
-					continue
-				}
-				result.Index(i).Set(v)
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// convertNumber converts the number src into dst,
+// and reports an error on overflow, or on loss of the fractional part
+// unless truncate is true.
+func convertNumber(dst, src reflect.Value, truncate bool) error {
+	in := fmt.Sprintf("%v", src.Interface())
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch src.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i = src.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if src.Uint() > math.MaxInt64 {
+				return fmt.Errorf(msgDetailOverflow, in, dst.Type())
 			}
-		} else {
-			var e []*DecodeFieldError
-			result, e = makeArrayStruct(name, in, out[0], o)
-			if len(e) > 0 {
-				decErrs = append(decErrs, e...)
+			i = int64(src.Uint())
+		case reflect.Float32, reflect.Float64:
+			f := src.Float()
+			if f != math.Trunc(f) && !truncate {
+				return fmt.Errorf(msgDetailFraction, in, dst.Type())
+			}
+			f = math.Trunc(f)
+			if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return fmt.Errorf(msgDetailOverflow, in, dst.Type())
 			}
+			i = int64(f)
 		}
-	case reflect.Slice:
-		if len(out) > 1 {
-			result = reflect.MakeSlice(out[0], 0, in.Len())
-			for i := 0; i < in.Len(); i++ {
-				newName := name + "[" + fmt.Sprint(i) + "]"
-				v, e := makeCollections(newName, in.Index(i), out[1:], o)
-				if len(e) > 0 {
-					decErrs = append(decErrs, e...)
-					continue
-				}
-				result = reflect.Append(result, v)
+		if dst.OverflowInt(i) {
+			return fmt.Errorf(msgDetailOverflow, in, dst.Type())
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch src.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if src.Int() < 0 {
+				return fmt.Errorf(msgDetailNegative, in, dst.Type())
 			}
-		} else {
-			var e []*DecodeFieldError
-			result, e = makeSliceStruct(name, in, out[0], o)
-			if len(e) > 0 {
-				decErrs = append(decErrs, e...)
+			u = uint64(src.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u = src.Uint()
+		case reflect.Float32, reflect.Float64:
+			f := src.Float()
+			if f != math.Trunc(f) && !truncate {
+				return fmt.Errorf(msgDetailFraction, in, dst.Type())
+			}
+			f = math.Trunc(f)
+			if f < 0 {
+				return fmt.Errorf(msgDetailNegative, in, dst.Type())
+			}
+			if math.IsNaN(f) || f >= math.MaxUint64 {
+				return fmt.Errorf(msgDetailOverflow, in, dst.Type())
 			}
+			u = uint64(f)
 		}
+		if dst.OverflowUint(u) {
+			return fmt.Errorf(msgDetailOverflow, in, dst.Type())
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch src.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(src.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f = float64(src.Uint())
+		case reflect.Float32, reflect.Float64:
+			f = src.Float()
+		}
+		if dst.OverflowFloat(f) {
+			return fmt.Errorf(msgDetailOverflow, in, dst.Type())
+		}
+		dst.SetFloat(f)
 	}
-	return result, decErrs
+	return nil
 }
 
-func makeArrayStruct(name string, in reflect.Value, out reflect.Type, o DecodeMapOptions) (reflect.Value, []*DecodeFieldError) {
-	result := reflect.New(out).Elem()
-	var decErrs []*DecodeFieldError
-	for i := 0; i < in.Len(); i++ {
-		newName := name + "[" + fmt.Sprint(i) + "]"
-		t := out.Elem()
-		if isNil(in.Index(i)) {
-			v := reflect.Zero(t)
-			result.Index(i).Set(v)
-			continue
+// setReflectValue sets src to dst if the type of src is assignable,
+// and reports whether the types matched.
+func setReflectValue(dst, src reflect.Value) bool {
+	if src.Type() == dst.Type() ||
+		dst.Type().Kind() == reflect.Interface &&
+			src.Type().Implements(dst.Type()) {
+		dst.Set(src)
+		return true
+	}
+	if dst.Type().Kind() == reflect.Ptr && src.Type().Kind() != reflect.Ptr {
+		dstET := dst.Type().Elem()
+		eqType := src.Type() == dstET
+		impl := dstET.Kind() == reflect.Interface && src.Type().Implements(dstET)
+		if !eqType && !impl {
+			return false
 		}
-		ptr := false
-		if t.Kind() == reflect.Ptr {
-			t = t.Elem()
-			ptr = true
+		if !isNil(src) {
+			rv := reflect.New(dstET)
+			rv.Elem().Set(src)
+			dst.Set(rv)
+			// dst.Set(src.Addr())
 		}
-		pv := reflect.New(t)
-		sv := pv.Elem()
-		e := mapToStruct(newName, in.Index(i).Interface(), sv, o)
-		if len(e) > 0 {
-			decErrs = append(decErrs, e...)
-			continue
+		return true
+	}
+	return false
+}
+
+// EncodeMap encodes a struct into a map.
+// Nested structs are encoded into maps, and so are the structs in collections,
+// so that DecodeMap can decode the result into the same struct.
+func EncodeMap(v interface{}, o *EncodeMapOptions) (map[string]interface{}, error) {
+	opts := initEncodeMapOptions(o)
+	s, err := checkStruct(v)
+	if err != nil {
+		return nil, err
+	}
+	return structToMap(s, opts)
+}
+
+func initEncodeMapOptions(o *EncodeMapOptions) EncodeMapOptions {
+	var result EncodeMapOptions
+	if o != nil {
+		result = *o
+	}
+	if result.TagName == "" {
+		result.TagName = mapTagName
+	}
+	return result
+}
+
+func structToMap(s reflect.Value, o EncodeMapOptions) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	var err error
+	walkStructFields(nil, s, o.TagName, func(f fieldInfo) {
+		if err != nil {
+			return
 		}
-		v := sv
-		if ptr {
-			v = v.Addr()
+		fm := f.Meta
+		tag := f.Tag
+		if f.TagErr != nil {
+			err = f.TagErr
+			return
+		}
+		if o.TagOnly && !f.ChildOK && !tag.OK {
+			return
+		}
+		if tag.Omitted {
+			return
 		}
-		result.Index(i).Set(v)
+		if tag.OmitEmpty && isEmptyValue(f.Value) {
+			return
+		}
+
+		key := fm.Name
+		if tag.OK && tag.Key != "" {
+			key = tag.Key
+		}
+
+		var v interface{}
+		v, err = encodeMapValue(f, o)
+		if err != nil {
+			return
+		}
+		m[key] = v
+	})
+	if err != nil {
+		return nil, err
 	}
-	return result, decErrs
+	return m, nil
 }
 
-func makeSliceStruct(name string, in reflect.Value, out reflect.Type, o DecodeMapOptions) (reflect.Value, []*DecodeFieldError) {
-	result := reflect.MakeSlice(out, 0, in.Len())
-	var decErrs []*DecodeFieldError
-	for i := 0; i < in.Len(); i++ {
-		newName := name + "[" + fmt.Sprint(i) + "]"
-		t := out.Elem()
-		if isNil(in.Index(i)) {
-			v := reflect.Zero(t)
-			result = reflect.Append(result, v)
-			continue
+func encodeMapValue(f fieldInfo, o EncodeMapOptions) (interface{}, error) {
+	switch {
+	case f.ChildOK && hasExportedFields(f.Child.Type()):
+		return structToMap(f.Child, o)
+	case len(f.Collections) > 0:
+		leaf := f.Collections[len(f.Collections)-1].Elem()
+		for leaf.Kind() == reflect.Ptr {
+			leaf = leaf.Elem()
 		}
-		ptr := false
-		if t.Kind() == reflect.Ptr {
-			t = t.Elem()
-			ptr = true
+		if !hasExportedFields(leaf) {
+			break
 		}
-		pv := reflect.New(t)
-		sv := pv.Elem()
-		e := mapToStruct(newName, in.Index(i).Interface(), sv, o)
-		if len(e) > 0 {
-			decErrs = append(decErrs, e...)
-			continue
+		cv, err := encodeCollections(f.Value, o)
+		if err != nil {
+			return nil, err
 		}
-		v := sv
-		if ptr {
-			v = v.Addr()
+		return cv.Interface(), nil
+	}
+	return f.Value.Interface(), nil
+}
+
+func encodeCollections(in reflect.Value, o EncodeMapOptions) (reflect.Value, error) {
+	switch in.Kind() {
+	case reflect.Array, reflect.Slice:
+		t := encodedCollectionType(in.Type())
+		var result reflect.Value
+		if in.Kind() == reflect.Array {
+			result = reflect.New(t).Elem()
+		} else {
+			if in.IsNil() {
+				return reflect.Zero(t), nil
+			}
+			result = reflect.MakeSlice(t, in.Len(), in.Len())
 		}
-		result = reflect.Append(result, v)
+		for i := 0; i < in.Len(); i++ {
+			v, err := encodeCollections(in.Index(i), o)
+			if err != nil {
+				return v, err
+			}
+			result.Index(i).Set(v)
+		}
+		return result, nil
+	}
+
+	s, ok := followStruct(in, false)
+	if !ok {
+		return reflect.Zero(mapInterfaceType), nil
+	}
+	m, err := structToMap(s, o)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(m), nil
+}
+
+// encodedCollectionType returns the collection type whose struct elements
+// are replaced with maps.
+func encodedCollectionType(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.Array:
+		return reflect.ArrayOf(t.Len(), encodedCollectionType(t.Elem()))
+	case reflect.Slice:
+		return reflect.SliceOf(encodedCollectionType(t.Elem()))
 	}
-	return result, decErrs
+	return mapInterfaceType
 }
 
 func isNil(v reflect.Value) bool {
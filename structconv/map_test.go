@@ -6,8 +6,10 @@ package structconv
 
 import (
 	"errors"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type testMapInterface struct {
@@ -102,6 +104,7 @@ func TestDecodeMap(t *testing.T) {
 	tests := []struct {
 		name string
 		in   map[string]interface{}
+		opts *DecodeMapOptions
 		want interface{}
 		got  interface{}
 	}{
@@ -177,6 +180,7 @@ func TestDecodeMap(t *testing.T) {
 				"D": &testInterfaceZero,
 				"E": &testMapI{},
 			},
+			opts: &DecodeMapOptions{IgnoreInvalidType: true},
 			want: testMapI{
 				A: 5,
 				B: testMapI{A: 1},
@@ -255,7 +259,7 @@ func TestDecodeMap(t *testing.T) {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			err := DecodeMap(tt.in, tt.got, nil)
+			err := DecodeMap(tt.in, tt.got, tt.opts)
 			if err != nil {
 				t.Error(err)
 			}
@@ -266,3 +270,860 @@ func TestDecodeMap(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeMap(t *testing.T) {
+	type testMap3 struct {
+		E int
+	}
+	type testMap2 struct {
+		C int
+		D *testMap3
+	}
+	type testMap1 struct {
+		A         int    `map:"a"`
+		B         string `map:"-"`
+		Nest      testMap2
+		NilPtr    *testMap2
+		Slice     [][]*testMap3
+		Array     [2]testMap3
+		Ints      []int
+		Untouched map[string]int
+	}
+
+	tests := []struct {
+		name string
+		in   interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "variation",
+			in: testMap1{
+				A:    1,
+				B:    "b",
+				Nest: testMap2{C: 2, D: &testMap3{E: 3}},
+				Slice: [][]*testMap3{
+					{{E: 4}, nil},
+					nil,
+				},
+				Array:     [2]testMap3{{E: 5}},
+				Ints:      []int{6},
+				Untouched: map[string]int{"x": 7},
+			},
+			want: map[string]interface{}{
+				"a": 1,
+				"Nest": map[string]interface{}{
+					"C": 2,
+					"D": map[string]interface{}{"E": 3},
+				},
+				"NilPtr": (*testMap2)(nil),
+				"Slice": [][]map[string]interface{}{
+					{{"E": 4}, nil},
+					nil,
+				},
+				"Array": [2]map[string]interface{}{
+					{"E": 5},
+					{"E": 0},
+				},
+				"Ints":      []int{6},
+				"Untouched": map[string]int{"x": 7},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := EncodeMap(tt.in, nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeMapRoundTrip(t *testing.T) {
+	type inner struct {
+		C int
+		D string `map:"dd"`
+	}
+	type outer struct {
+		A      int `map:"AA,required"`
+		B      []inner
+		Ptr    *inner
+		Nested [][]*inner
+		Array  [2][1]*inner
+		When   time.Time
+		Ints   []int
+	}
+
+	in := outer{
+		A:      1,
+		B:      []inner{{C: 2, D: "foo"}, {C: 3, D: "bar"}},
+		Ptr:    &inner{C: 4},
+		Nested: [][]*inner{{{C: 5}, nil}, nil},
+		Array:  [2][1]*inner{{{D: "baz"}}},
+		When:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Ints:   []int{6, 7},
+	}
+	m, err := EncodeMap(&in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got outer
+	if err := DecodeMap(m, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", in, got)
+	}
+}
+
+func TestDecodeMapDefault(t *testing.T) {
+	type testDefault2 struct {
+		C int `map:",default=3"`
+	}
+	type testDefault1 struct {
+		A  int           `map:",default=1"`
+		B  time.Duration `map:",default=2s"`
+		N1 testDefault2
+		N2 testDefault2
+		N3 *testDefault2
+	}
+	type testInvalidDefault struct {
+		A int `map:",default=x"`
+		N testDefault1
+		M struct {
+			B bool `map:",default=maybe"`
+		}
+		S []testDefault2 `map:",default=x"`
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]interface{}
+		got     interface{}
+		want    interface{}
+		wantErr []string
+	}{
+		{
+			name: "normal",
+			in: map[string]interface{}{
+				"B":  time.Second,
+				"N2": map[string]interface{}{"C": 4},
+			},
+			got: &testDefault1{},
+			want: testDefault1{
+				A:  1,
+				B:  time.Second,
+				N1: testDefault2{C: 3},
+				N2: testDefault2{C: 4},
+			},
+		},
+		{
+			name:    "invalid default",
+			in:      map[string]interface{}{},
+			got:     &testInvalidDefault{},
+			want:    testInvalidDefault{N: testDefault1{A: 1, B: 2 * time.Second, N1: testDefault2{C: 3}, N2: testDefault2{C: 3}}},
+			wantErr: []string{"map[A]", "map[M][B]", "map[S]"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := DecodeMap(tt.in, tt.got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			got := reflect.ValueOf(tt.got).Elem().Interface()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeMapNestedError(t *testing.T) {
+	type testNested3 struct {
+		E int `map:",required"`
+	}
+	type testNested2 struct {
+		C int `map:",required"`
+		D [][]*testNested3
+	}
+	type testNested1 struct {
+		A testNested2
+		B []testNested2
+		F [2]testNested2
+		G [][1]*testNested3
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]interface{}
+		wantErr []string
+	}{
+		{
+			name: "nested struct",
+			in: map[string]interface{}{
+				"A": map[string]interface{}{
+					"D": [][]map[string]interface{}{{{"E": 1}, {}}},
+				},
+			},
+			wantErr: []string{"map[A][C]", "map[A][D][0][1][E]"},
+		},
+		{
+			name: "collections",
+			in: map[string]interface{}{
+				"B": []map[string]interface{}{
+					{"C": 1},
+					{"D": [][]map[string]interface{}{nil, {{}}}},
+				},
+				"F": [2]map[string]interface{}{{"C": 1}, {}},
+			},
+			wantErr: []string{"map[B][1][C]", "map[B][1][D][1][0][E]", "map[F][1][C]"},
+		},
+		{
+			name: "collection type mismatch",
+			in: map[string]interface{}{
+				"B": map[string]interface{}{"C": 1},
+				"F": []map[string]interface{}{{"C": 1}, {"C": 2}, {"C": 3}},
+				"G": [][2]map[string]interface{}{{{"E": 1}}},
+			},
+			wantErr: []string{"map[B]", "map[F]", "map[G][0]"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testNested1
+			err := DecodeMap(tt.in, &got, nil)
+			var decErr *DecodeError
+			if !errors.As(err, &decErr) {
+				t.Fatalf("want *DecodeError, got %v", err)
+			}
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+		})
+	}
+}
+
+func TestDecodeMapElementWise(t *testing.T) {
+	type testElem struct {
+		A int
+	}
+	type testElementWise struct {
+		Ports   []int
+		Hosts   []string
+		Matrix  [][]int
+		Array   [3]uint8
+		Ptrs    []*int
+		Structs []testElem
+		Nested  [][1]*testElem
+	}
+	one := 1
+
+	tests := []struct {
+		name    string
+		in      map[string]interface{}
+		opts    *DecodeMapOptions
+		want    testElementWise
+		wantErr []string
+	}{
+		{
+			name: "normal",
+			in: map[string]interface{}{
+				"Ports":   []interface{}{80, 443},
+				"Hosts":   []interface{}{"a", "b"},
+				"Matrix":  []interface{}{[]interface{}{1, 2}, []int{3}, nil},
+				"Array":   []interface{}{uint8(1), uint8(2)},
+				"Ptrs":    []interface{}{1, nil},
+				"Structs": []interface{}{map[string]interface{}{"A": 1}},
+				"Nested": []interface{}{
+					[]interface{}{map[string]interface{}{"A": 1}},
+				},
+			},
+			want: testElementWise{
+				Ports:   []int{80, 443},
+				Hosts:   []string{"a", "b"},
+				Matrix:  [][]int{{1, 2}, {3}, nil},
+				Array:   [3]uint8{1, 2, 0},
+				Ptrs:    []*int{&one, nil},
+				Structs: []testElem{{A: 1}},
+				Nested:  [][1]*testElem{{{A: 1}}},
+			},
+		},
+		{
+			name: "weakly typed",
+			in: map[string]interface{}{
+				"Ports": []interface{}{float64(80), "443"},
+				"Hosts": []interface{}{"a", 1},
+				"Array": []interface{}{float64(1), "2", true},
+			},
+			opts: &DecodeMapOptions{WeaklyTyped: true},
+			want: testElementWise{
+				Ports: []int{80, 443},
+				Hosts: []string{"a", "1"},
+			},
+			wantErr: []string{"map[Array][2]"},
+		},
+		{
+			name: "invalid element",
+			in: map[string]interface{}{
+				"Ports":   []interface{}{80, 443, 8080, "a"},
+				"Matrix":  []interface{}{[]interface{}{1, "b"}},
+				"Array":   []interface{}{uint8(1), uint8(2), uint8(3), uint8(4)},
+				"Structs": []interface{}{map[string]interface{}{"A": "c"}},
+				"Hosts":   []interface{}{"a"},
+			},
+			want: testElementWise{
+				Hosts: []string{"a"},
+			},
+			wantErr: []string{
+				"map[Array]",
+				"map[Matrix][0][1]",
+				"map[Ports][3]",
+				"map[Structs][0][A]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testElementWise
+			err := DecodeMap(tt.in, &got, tt.opts)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeMapMapField(t *testing.T) {
+	type testBackend struct {
+		Host string `map:",required"`
+		Port int
+	}
+	type testMapField struct {
+		Backends map[string]testBackend
+		Ptrs     map[string]*testBackend
+		Lists    map[string][]int
+		Ints     map[int]string
+		Uints    map[uint8]bool
+		Levels   map[testStringMapLevel]int
+		Nested   map[string]map[string]int
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]interface{}
+		want    testMapField
+		wantErr []string
+	}{
+		{
+			name: "normal",
+			in: map[string]interface{}{
+				"Backends": map[string]interface{}{
+					"a": map[string]interface{}{"Host": "a.example", "Port": 80},
+				},
+				"Ptrs": map[string]interface{}{
+					"b": map[string]interface{}{"Host": "b.example"},
+					"c": nil,
+				},
+				"Lists":  map[string]interface{}{"d": []interface{}{1, 2}},
+				"Ints":   map[string]interface{}{"1": "one", "0x10": "sixteen"},
+				"Uints":  map[string]bool{"2": true},
+				"Levels": map[string]interface{}{"high": 3},
+				"Nested": map[string]interface{}{"e": map[string]interface{}{"f": 4}},
+			},
+			want: testMapField{
+				Backends: map[string]testBackend{"a": {Host: "a.example", Port: 80}},
+				Ptrs: map[string]*testBackend{
+					"b": {Host: "b.example"},
+					"c": nil,
+				},
+				Lists:  map[string][]int{"d": {1, 2}},
+				Ints:   map[int]string{1: "one", 16: "sixteen"},
+				Uints:  map[uint8]bool{2: true},
+				Levels: map[testStringMapLevel]int{2: 3},
+				Nested: map[string]map[string]int{"e": {"f": 4}},
+			},
+		},
+		{
+			name: "invalid",
+			in: map[string]interface{}{
+				"Backends": map[string]interface{}{
+					"a": map[string]interface{}{"Port": "80"},
+					"b": 1,
+				},
+				"Lists":  map[string]interface{}{"c": []interface{}{1, "2"}},
+				"Ints":   map[string]interface{}{"x": "one"},
+				"Uints":  map[string]interface{}{"256": true},
+				"Levels": map[string]interface{}{"middle": 1},
+				"Nested": map[string]interface{}{"d": map[string]interface{}{"e": "f"}},
+			},
+			wantErr: []string{
+				"map[Backends][a][Host]",
+				"map[Backends][a][Port]",
+				"map[Backends][b]",
+				"map[Ints][x]",
+				"map[Levels][middle]",
+				"map[Lists][c][1]",
+				"map[Nested][d][e]",
+				"map[Uints][256]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testMapField
+			err := DecodeMap(tt.in, &got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeMapErrorUnused(t *testing.T) {
+	type testUnusedChild struct {
+		Host string
+		Port int `map:"port"`
+	}
+	type testUnused struct {
+		Name     string
+		Omitted  string `map:"-"`
+		Child    testUnusedChild
+		Children []testUnusedChild
+		Backends map[string]testUnusedChild
+	}
+
+	tests := []struct {
+		name string
+		in   map[string]interface{}
+		want []*DecodeFieldError
+	}{
+		{
+			name: "all used",
+			in: map[string]interface{}{
+				"Name":  "a",
+				"Child": map[string]interface{}{"Host": "b", "port": 1},
+			},
+		},
+		{
+			name: "unused",
+			in: map[string]interface{}{
+				"Nmae":    "a",
+				"Omitted": "b",
+				"Child":   map[string]interface{}{"Hots": "c", "Port": 1},
+				"Children": []interface{}{
+					map[string]interface{}{"Host": "d", "Extra": true},
+				},
+				"Backends": map[string]interface{}{
+					"e": map[string]interface{}{"hst": "f"},
+				},
+			},
+			want: []*DecodeFieldError{
+				{
+					Name:     "map[Child][Hots]",
+					Value:    "c",
+					Messages: []string{"Hots is not used", "did you mean Host?"},
+				},
+				{
+					Name:     "map[Child][Port]",
+					Value:    "1",
+					Messages: []string{"Port is not used", "did you mean port?"},
+				},
+				{
+					Name:     "map[Children][0][Extra]",
+					Value:    "true",
+					Messages: []string{"Extra is not used"},
+				},
+				{
+					Name:     "map[Backends][e][hst]",
+					Value:    "f",
+					Messages: []string{"hst is not used", "did you mean Host?"},
+				},
+				{
+					Name:     "map[Nmae]",
+					Value:    "a",
+					Messages: []string{"Nmae is not used", "did you mean Name?"},
+				},
+				{
+					Name:     "map[Omitted]",
+					Value:    "b",
+					Messages: []string{"Omitted is not used"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testUnused
+			err := DecodeMap(tt.in, &got, &DecodeMapOptions{ErrorUnused: true})
+			var detail []*DecodeFieldError
+			var decErr *DecodeError
+			if errors.As(err, &decErr) {
+				detail = decErr.Detail
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(detail, tt.want) {
+				t.Errorf("\nwant = %v\ngot  = %v", tt.want, detail)
+			}
+		})
+	}
+}
+
+func TestDecodeMapMetadata(t *testing.T) {
+	type testMetadataChild struct {
+		Host string
+		Port int `map:",default=80"`
+	}
+	type testMetadata struct {
+		Name     string `map:",required"`
+		Level    int    `map:",default=1"`
+		Unset    string
+		Nil      *int
+		Child    testMetadataChild
+		Absent   testMetadataChild
+		Children []testMetadataChild
+	}
+
+	in := map[string]interface{}{
+		"Level":    2,
+		"Nil":      nil,
+		"Child":    map[string]interface{}{"Host": "a", "Extra": 1},
+		"Children": []interface{}{map[string]interface{}{"Host": "b", "Other": 2}},
+		"Unknown":  true,
+	}
+	want := Metadata{
+		Set: []string{
+			"map[Level]",
+			"map[Nil]",
+			"map[Child][Host]",
+			"map[Children]",
+		},
+		Defaulted: []string{"map[Child][Port]", "map[Absent][Port]"},
+		Unset:     []string{"map[Unset]", "map[Absent][Host]"},
+		Missing:   []string{"map[Name]"},
+		Unused: []string{
+			"map[Child][Extra]",
+			"map[Children][0][Other]",
+			"map[Unknown]",
+		},
+	}
+
+	var got testMetadata
+	meta := Metadata{Set: []string{"stale"}}
+	err := DecodeMap(in, &got, &DecodeMapOptions{Metadata: &meta})
+	if names := decodeErrorNames(err); !reflect.DeepEqual(names, []string{"map[Name]"}) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, meta)
+	}
+}
+
+func TestDecodeMapInvalidType(t *testing.T) {
+	type testInvalidType struct {
+		Int       int
+		IntPtr    *int
+		String    string
+		Slice     []int
+		Map       map[string]int
+		Interface interface{ test() }
+		Valid     int
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]interface{}
+		opts    *DecodeMapOptions
+		want    testInvalidType
+		wantErr []string
+	}{
+		{
+			name: "strict",
+			in: map[string]interface{}{
+				"Int":       "8080",
+				"IntPtr":    int64(1),
+				"String":    1,
+				"Slice":     []string{"a"},
+				"Map":       map[string]string{"a": "b"},
+				"Interface": 1,
+				"Valid":     2,
+			},
+			want: testInvalidType{Valid: 2},
+			wantErr: []string{
+				"map[IntPtr]",
+				"map[Int]",
+				"map[Interface]",
+				"map[Map][a]",
+				"map[Slice][0]",
+				"map[String]",
+			},
+		},
+		{
+			name: "ignore invalid type",
+			in: map[string]interface{}{
+				"Int":   "8080",
+				"Valid": 2,
+			},
+			opts: &DecodeMapOptions{IgnoreInvalidType: true},
+			want: testInvalidType{Valid: 2},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testInvalidType
+			err := DecodeMap(tt.in, &got, tt.opts)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeMapRecoverPanic(t *testing.T) {
+	type testPanic struct {
+		A map[testPanicUnmarshaler]int
+		B string
+	}
+
+	// The string keys of maps are decoded by the unmarshaler.
+	var got testPanic
+	in := map[string]interface{}{"A": map[string]int{"a": 1}, "B": "b"}
+	err := DecodeMap(in, &got, nil)
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) || len(decErr.Detail) != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &DecodeFieldError{Name: "map[A]", Messages: []string{"unexpected panic: unmarshal a"}}
+	if !reflect.DeepEqual(decErr.Detail[0], want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, decErr.Detail[0])
+	}
+	if got.B != "b" {
+		t.Errorf("the other fields must be decoded: %+v", got)
+	}
+}
+
+func TestDecodeMapWeaklyTyped(t *testing.T) {
+	type testWeak struct {
+		Int      int
+		Int8     int8
+		Uint     uint
+		UintPtr  *uint
+		Float32  float32
+		Bool     bool
+		String   string
+		Duration time.Duration
+		Time     time.Time `map:",layout=2006-01-02"`
+		IP       net.IP
+		Exact    int
+	}
+
+	uintVal := uint(7)
+	tests := []struct {
+		name    string
+		in      map[string]interface{}
+		want    testWeak
+		wantErr []string
+	}{
+		{
+			name: "normal",
+			in: map[string]interface{}{
+				"Int":      float64(8080),
+				"Int8":     "-0x10",
+				"Uint":     int64(3),
+				"UintPtr":  "7",
+				"Float32":  2,
+				"Bool":     "true",
+				"String":   0.5,
+				"Duration": "1m",
+				"Time":     "2020-01-02",
+				"IP":       "192.0.2.1",
+				"Exact":    1,
+			},
+			want: testWeak{
+				Int:      8080,
+				Int8:     -16,
+				Uint:     3,
+				UintPtr:  &uintVal,
+				Float32:  2,
+				Bool:     true,
+				String:   "0.5",
+				Duration: time.Minute,
+				Time:     time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+				IP:       net.ParseIP("192.0.2.1"),
+				Exact:    1,
+			},
+		},
+		{
+			name: "error",
+			in: map[string]interface{}{
+				"Int":     1.5,
+				"Int8":    300,
+				"Uint":    -1,
+				"UintPtr": "x",
+				"Float32": 1e300,
+				"Bool":    1,
+				"String":  []int{1},
+			},
+			wantErr: []string{
+				"map[Bool]",
+				"map[Float32]",
+				"map[Int8]",
+				"map[Int]",
+				"map[String]",
+				"map[UintPtr]",
+				"map[Uint]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testWeak
+			err := DecodeMap(tt.in, &got, &DecodeMapOptions{WeaklyTyped: true})
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeMapConv(t *testing.T) {
+	type testConv struct {
+		Int8    int8    `map:",conv"`
+		Uint    uint    `map:",conv"`
+		Float32 float32 `map:",conv"`
+		Int     int     `map:",conv"`
+		String  string  `map:",conv"`
+		Array   [2]int  `map:",conv"`
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]interface{}
+		want    testConv
+		wantErr []string
+	}{
+		{
+			name: "normal",
+			in: map[string]interface{}{
+				"Int8":    int64(-128),
+				"Uint":    float64(3.7),
+				"Float32": 1,
+				"String":  []byte("s"),
+			},
+			want: testConv{
+				Int8:    -128,
+				Uint:    3,
+				Float32: 1,
+				String:  "s",
+			},
+		},
+		{
+			name: "error",
+			in: map[string]interface{}{
+				"Int8":    128,
+				"Uint":    -1,
+				"Float32": 1e300,
+				"Int":     map[string]int{},
+				"Array":   []int{1},
+			},
+			wantErr: []string{
+				"map[Array]",
+				"map[Float32]",
+				"map[Int8]",
+				"map[Int]",
+				"map[Uint]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testConv
+			err := DecodeMap(tt.in, &got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeMapNoPanic(t *testing.T) {
+	type testPanic2 struct {
+		A int
+	}
+	type testPanic1 struct {
+		Nested testPanic2
+		Slice  []testPanic2
+		Array  [1][2]*testPanic2
+		Conv   int `map:",conv"`
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]interface{}
+		wantErr []string
+	}{
+		{
+			name:    "nested is not a map",
+			in:      map[string]interface{}{"Nested": 1},
+			wantErr: []string{"map[Nested]"},
+		},
+		{
+			name:    "collection elements are not maps",
+			in:      map[string]interface{}{"Slice": []int{1}, "Array": [1][2]interface{}{{"x", nil}}},
+			wantErr: []string{"map[Array][0][0]", "map[Slice][0]"},
+		},
+		{
+			name:    "inconvertible",
+			in:      map[string]interface{}{"Conv": map[string]int{}},
+			wantErr: []string{"map[Conv]"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testPanic1
+			err := DecodeMap(tt.in, &got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+// Metadata is the information about what happened in decoding.
+// The fields and the keys are named in the same way as DecodeFieldError.
+type Metadata struct {
+	// Set is the fields set from the source.
+	Set []string
+	// Defaulted is the fields set from the default tag option.
+	Defaulted []string
+	// Unset is the fields missing from the source and left unchanged.
+	Unset []string
+	// Missing is the required fields missing from the source.
+	Missing []string
+	// Unused is the keys of the source not decoded into any field.
+	Unused []string
+}
+
+func (m *Metadata) addSet(name string) {
+	if m != nil {
+		m.Set = append(m.Set, name)
+	}
+}
+
+func (m *Metadata) addDefaulted(name string) {
+	if m != nil {
+		m.Defaulted = append(m.Defaulted, name)
+	}
+}
+
+func (m *Metadata) addUnset(name string) {
+	if m != nil {
+		m.Unset = append(m.Unset, name)
+	}
+}
+
+func (m *Metadata) addMissing(name string) {
+	if m != nil {
+		m.Missing = append(m.Missing, name)
+	}
+}
+
+func (m *Metadata) addUnused(name string) {
+	if m != nil {
+		m.Unused = append(m.Unused, name)
+	}
+}
+
+// reset clears the metadata for a new decoding.
+func (m *Metadata) reset() {
+	if m != nil {
+		*m = Metadata{}
+	}
+}
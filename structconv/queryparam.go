@@ -16,16 +16,20 @@ type DecodeQueryParamOptions struct {
 	TagName      string
 	TagOnly      bool
 	KeyConverter func(string) string
+	// ErrorUnused reports the keys that are not decoded into any field.
+	ErrorUnused bool
+}
+
+type EncodeQueryParamOptions struct {
+	TagName      string
+	TagOnly      bool
+	KeyConverter func(string) string
 }
 
 // DecodeQueryParam decodes query parameters into a struct.
+// The collection and map fields take all the values of each key
+// as the elements or the pairs. A single value is split by the separator.
 func DecodeQueryParam(u url.Values, v interface{}, o *DecodeQueryParamOptions) error {
-	m := map[string]string{}
-	for k, v := range u {
-		if len(v) > 0 {
-			m[k] = v[0]
-		}
-	}
 	if o == nil {
 		o = &DecodeQueryParamOptions{}
 	}
@@ -36,6 +40,27 @@ func DecodeQueryParam(u url.Values, v interface{}, o *DecodeQueryParamOptions) e
 		TagName:      o.TagName,
 		TagOnly:      o.TagOnly,
 		KeyConverter: o.KeyConverter,
+		ErrorUnused:  o.ErrorUnused,
+	}
+	return DecodeSource(ValuesSource(u), v, opts)
+}
+
+// EncodeQueryParam encodes a struct into query parameters.
+// Slice, array and map fields are encoded into multiple values,
+// which DecodeQueryParam decodes back into the field,
+// unless a single element or pair contains the separator.
+// The keys are sorted by url.Values.Encode, so the encoded string is deterministic.
+func EncodeQueryParam(v interface{}, o *EncodeQueryParamOptions) (url.Values, error) {
+	if o == nil {
+		o = &EncodeQueryParamOptions{}
+	}
+	if o.TagName == "" {
+		o.TagName = queryParamTagName
+	}
+	opts := &EncodeStringMapOptions{
+		TagName:      o.TagName,
+		TagOnly:      o.TagOnly,
+		KeyConverter: o.KeyConverter,
 	}
-	return DecodeStringMap(m, v, opts)
+	return encodeValues(v, opts)
 }
@@ -6,6 +6,7 @@ package structconv
 
 import (
 	"net/url"
+	"reflect"
 	"testing"
 )
 
@@ -49,3 +50,90 @@ func TestDecodeQueryParam(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeQueryParam(t *testing.T) {
+	type queryParamTestEncode struct {
+		String  string
+		Bool    bool `queryparam:",omitempty"`
+		Int     int
+		Float64 float64
+		Rename  string   `queryparam:"q"`
+		Omitted string   `queryparam:"-"`
+		Empty   string   `queryparam:",omitempty"`
+		Slice   []string `queryparam:"s"`
+		Array   [2]int
+		Ptr     *int `queryparam:",omitempty"`
+	}
+
+	tests := []struct {
+		name string
+		in   queryParamTestEncode
+		want string
+	}{
+		{
+			"normal",
+			queryParamTestEncode{
+				String:  "a b",
+				Int:     1,
+				Float64: 0.3,
+				Rename:  "x&y",
+				Omitted: "-",
+				Slice:   []string{"c", "b", "a"},
+				Array:   [2]int{2, 3},
+			},
+			"Array=2&Array=3&Float64=0.3&Int=1&String=a+b&q=x%26y&s=c&s=b&s=a",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			u, err := EncodeQueryParam(tt.in, nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if got := u.Encode(); got != tt.want {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestQueryParamRoundTrip(t *testing.T) {
+	type queryParamTestRoundTrip struct {
+		IDs    []int
+		Names  []string `queryparam:"name"`
+		Array  [3]int
+		Ptrs   []*int
+		Labels map[string]string
+		Commas []string `queryparam:"tags"`
+		Single []string
+		Empty  []string
+	}
+	one := 1
+	in := queryParamTestRoundTrip{
+		IDs:    []int{1, 2, 3},
+		Names:  []string{"a", "", "b c"},
+		Array:  [3]int{4, 5},
+		Ptrs:   []*int{&one},
+		Labels: map[string]string{"a": "1", "b": "2", "k": "v,w"},
+		Commas: []string{"a,b", "c"},
+		Single: []string{"x"},
+	}
+
+	u, err := EncodeQueryParam(in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = url.ParseQuery(u.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got queryParamTestRoundTrip
+	if err := DecodeQueryParam(u, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", in, got)
+	}
+}
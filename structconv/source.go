@@ -0,0 +1,285 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+const msgDetailLayer = "supplied by the %v layer"
+
+// Source is the interface implemented by string-keyed values
+// that DecodeSource decodes into a struct.
+type Source interface {
+	// Lookup returns the value of the key, and reports whether it exists.
+	Lookup(key string) (string, bool)
+}
+
+// KeysSource is the interface implemented by sources
+// that can also list their keys.
+// The ErrorUnused option and the unused keys of Metadata require it.
+type KeysSource interface {
+	Source
+	// Keys returns the keys of the source.
+	Keys() []string
+}
+
+// MapSource is the source of a string map.
+type MapSource map[string]string
+
+// Lookup implements Source.
+func (s MapSource) Lookup(key string) (string, bool) {
+	v, ok := s[key]
+	return v, ok
+}
+
+// Keys implements KeysSource.
+func (s MapSource) Keys() []string {
+	return sortedKeys(s)
+}
+
+// EnvSource is the source of environment variables.
+type EnvSource struct{}
+
+// Lookup implements Source by os.LookupEnv.
+func (EnvSource) Lookup(key string) (string, bool) {
+	return os.LookupEnv(key)
+}
+
+// Keys implements KeysSource.
+func (EnvSource) Keys() []string {
+	var keys []string
+	for _, e := range os.Environ() {
+		if i := strings.Index(e, "="); i >= 0 {
+			keys = append(keys, e[:i])
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValuesSource is the source of url.Values, such as query parameters
+// and form data. The collection and map fields take all the values
+// of each key as the elements or the pairs, and the other fields
+// take the first value.
+type ValuesSource url.Values
+
+// Lookup implements Source.
+func (s ValuesSource) Lookup(key string) (string, bool) {
+	if vs := s[key]; len(vs) > 0 {
+		return vs[0], true
+	}
+	return "", false
+}
+
+func (s ValuesSource) lookupValues(key string) ([]string, bool) {
+	vs := s[key]
+	return vs, len(vs) > 0
+}
+
+// Keys implements KeysSource.
+func (s ValuesSource) Keys() []string {
+	var keys []string
+	for k, vs := range s {
+		if len(vs) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HeaderSource is the source of HTTP headers.
+// The keys are canonicalized as http.Header.Get does,
+// and the values are taken in the same way as ValuesSource.
+type HeaderSource http.Header
+
+// Lookup implements Source.
+func (s HeaderSource) Lookup(key string) (string, bool) {
+	if vs := s[textproto.CanonicalMIMEHeaderKey(key)]; len(vs) > 0 {
+		return vs[0], true
+	}
+	return "", false
+}
+
+func (s HeaderSource) lookupValues(key string) ([]string, bool) {
+	return ValuesSource(s).lookupValues(textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// Keys implements KeysSource.
+func (s HeaderSource) Keys() []string {
+	return ValuesSource(s).Keys()
+}
+
+func (HeaderSource) normalizeKey(key string) string {
+	return textproto.CanonicalMIMEHeaderKey(key)
+}
+
+// Layer is a named source in Layered.
+type Layer struct {
+	// Name names the layer in the errors, such as "defaults" or "env".
+	Name   string
+	Source Source
+}
+
+// Layered is the source that merges the layers, such as defaults,
+// a config file, environment variables and flags.
+// Each key is resolved from the last layer that has it,
+// so the later layers take precedence over the earlier ones.
+// The decoding errors name the layer that supplied the bad value.
+type Layered []Layer
+
+// Lookup implements Source.
+func (l Layered) Lookup(key string) (string, bool) {
+	for i := len(l) - 1; i >= 0; i-- {
+		if v, ok := l[i].Source.Lookup(key); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (l Layered) lookupValues(key string) ([]string, bool) {
+	for i := len(l) - 1; i >= 0; i-- {
+		v, ok := l[i].Source.Lookup(key)
+		if !ok {
+			continue
+		}
+		if vs, ok := l[i].Source.(valuesSource); ok {
+			return vs.lookupValues(key)
+		}
+		return []string{v}, true
+	}
+	return nil, false
+}
+
+// Keys implements KeysSource.
+// It returns the keys of the layers that implement KeysSource.
+func (l Layered) Keys() []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, layer := range l {
+		src, ok := layer.Source.(KeysSource)
+		if !ok {
+			continue
+		}
+		for _, k := range src.Keys() {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sourceName returns the name of the layer that has the key.
+// The names of nested layers are joined with "/".
+func (l Layered) sourceName(key string) (string, bool) {
+	for i := len(l) - 1; i >= 0; i-- {
+		if _, ok := l[i].Source.Lookup(key); !ok {
+			continue
+		}
+		if n, ok := l[i].Source.(sourceNamer); ok {
+			if name, ok := n.sourceName(key); ok {
+				return l[i].Name + "/" + name, true
+			}
+		}
+		return l[i].Name, true
+	}
+	return "", false
+}
+
+// keyNormalizer is implemented by the sources that match the keys
+// in a normalized form, such as canonical header keys.
+type keyNormalizer interface {
+	normalizeKey(key string) string
+}
+
+// unknownKeys returns the keys of the source that are not known
+// and have the prefix, comparing them in the normalized form of each layer.
+func unknownKeys(src KeysSource, known map[string]bool, prefix string) []string {
+	if l, ok := src.(Layered); ok {
+		seen := map[string]bool{}
+		var keys []string
+		for _, layer := range l {
+			src, ok := layer.Source.(KeysSource)
+			if !ok {
+				continue
+			}
+			for _, k := range unknownKeys(src, known, prefix) {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	n, ok := src.(keyNormalizer)
+	if ok {
+		normalized := make(map[string]bool, len(known))
+		for k := range known {
+			normalized[n.normalizeKey(k)] = true
+		}
+		known = normalized
+		prefix = n.normalizeKey(prefix)
+	}
+	var keys []string
+	for _, k := range src.Keys() {
+		if !known[k] && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// valuesSource is implemented by the sources whose keys
+// can have multiple values.
+type valuesSource interface {
+	lookupValues(key string) ([]string, bool)
+}
+
+// sourceNamer is implemented by the sources that can name
+// the origin of the keys.
+type sourceNamer interface {
+	sourceName(key string) (string, bool)
+}
+
+// withSourceName adds the name of the source supplying the key
+// to the messages of the errors.
+func withSourceName(src Source, key string, errs ...*DecodeFieldError) []*DecodeFieldError {
+	n, ok := src.(sourceNamer)
+	if !ok {
+		return errs
+	}
+	name, ok := n.sourceName(key)
+	if !ok {
+		return errs
+	}
+	for _, e := range errs {
+		e.Messages = append(e.Messages, fmt.Sprintf(msgDetailLayer, name))
+	}
+	return errs
+}
+
+var errSourceKeys = errors.New("structconv: ErrorUnused requires the source to implement KeysSource")
+
+// DecodeSource decodes the source into a struct
+// in the same way as DecodeStringMap.
+func DecodeSource(src Source, v interface{}, o *DecodeStringMapOptions) error {
+	opts := initDecodeStringMapOptions(o)
+	return decodeSource(src, v, opts, nil)
+}
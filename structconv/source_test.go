@@ -0,0 +1,308 @@
+// Copyright (c) 2020 twihike. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package structconv
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// lookupSource is a source that cannot list its keys.
+type lookupSource func(key string) (string, bool)
+
+func (f lookupSource) Lookup(key string) (string, bool) {
+	return f(key)
+}
+
+func TestDecodeSource(t *testing.T) {
+	type sourceTest struct {
+		Name  string `strmap:"name"`
+		Port  int    `strmap:"port"`
+		Debug bool   `strmap:"x-debug"`
+	}
+	want := sourceTest{Name: "a", Port: 1, Debug: true}
+
+	tests := []struct {
+		name string
+		in   Source
+	}{
+		{
+			name: "map",
+			in:   MapSource{"name": "a", "port": "1", "x-debug": "true"},
+		},
+		{
+			name: "values",
+			in: ValuesSource(url.Values{
+				"name":    {"a", "b"},
+				"port":    {"1"},
+				"x-debug": {"true"},
+				"empty":   {},
+			}),
+		},
+		{
+			name: "header",
+			in: HeaderSource(http.Header{
+				"Name":    {"a"},
+				"Port":    {"1"},
+				"X-Debug": {"true"},
+			}),
+		},
+		{
+			name: "lookup only",
+			in: lookupSource(func(key string) (string, bool) {
+				v, ok := map[string]string{"name": "a", "port": "1", "x-debug": "true"}[key]
+				return v, ok
+			}),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got sourceTest
+			if err := DecodeSource(tt.in, &got, nil); err != nil {
+				t.Error(err)
+			}
+			if got != want {
+				t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeSourceKeys(t *testing.T) {
+	type sourceTest struct {
+		Name string
+	}
+
+	var got sourceTest
+	var meta Metadata
+	src := ValuesSource(url.Values{"Name": {"a"}, "Nmae": {"b"}, "Empty": {}})
+	err := DecodeSource(src, &got, &DecodeStringMapOptions{ErrorUnused: true, Metadata: &meta})
+	if names := decodeErrorNames(err); !reflect.DeepEqual(names, []string{"Nmae"}) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []string{"Nmae"}; !reflect.DeepEqual(meta.Unused, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, meta.Unused)
+	}
+
+	lookup := lookupSource(func(key string) (string, bool) { return "", false })
+	err = DecodeSource(lookup, &got, &DecodeStringMapOptions{ErrorUnused: true})
+	if !errors.Is(err, errSourceKeys) {
+		t.Errorf("\nwant = %v\ngot  = %v", errSourceKeys, err)
+	}
+	err = DecodeSource(lookup, &got, &DecodeStringMapOptions{Metadata: &meta})
+	if err != nil {
+		t.Error(err)
+	}
+	if want := []string{"Name"}; !reflect.DeepEqual(meta.Unset, want) || meta.Unused != nil {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+}
+
+func TestEnvSource(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("B", "b=c")
+	os.Setenv("A", "")
+
+	var src EnvSource
+	if got, want := src.Keys(), []string{"A", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+	if v, ok := src.Lookup("B"); v != "b=c" || !ok {
+		t.Errorf("unexpected lookup: %q, %v", v, ok)
+	}
+	if _, ok := src.Lookup("A"); !ok {
+		t.Error("A must exist")
+	}
+	if _, ok := src.Lookup("C"); ok {
+		t.Error("C must not exist")
+	}
+}
+
+func TestHeaderSource(t *testing.T) {
+	h := http.Header{}
+	h.Add("x-request-id", "1")
+	src := HeaderSource(h)
+	if v, ok := src.Lookup("x-request-id"); v != "1" || !ok {
+		t.Errorf("unexpected lookup: %q, %v", v, ok)
+	}
+	if got, want := src.Keys(), []string{"X-Request-Id"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+}
+
+func TestHeaderSourceErrorUnused(t *testing.T) {
+	type headerTest struct {
+		RequestID string `strmap:"x-request-id"`
+		Trace     struct {
+			ID string `strmap:"id"`
+		} `strmap:",prefix=x-trace-"`
+	}
+	h := http.Header{}
+	h.Add("x-request-id", "1")
+	h.Add("x-trace-id", "2")
+	h.Add("x-trace-idd", "3")
+	h.Add("x-other", "4")
+
+	var got headerTest
+	var meta Metadata
+	src := Layered{
+		{Name: "header", Source: HeaderSource(h)},
+		{Name: "map", Source: MapSource{"x-extra": "5"}},
+	}
+	err := DecodeSource(src, &got, &DecodeStringMapOptions{ErrorUnused: true, Metadata: &meta})
+	want := []string{"X-Other", "X-Trace-Idd", "x-extra"}
+	if names := decodeErrorNames(err); !reflect.DeepEqual(names, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, names)
+	}
+	if !reflect.DeepEqual(meta.Unused, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, meta.Unused)
+	}
+	if got.RequestID != "1" || got.Trace.ID != "2" {
+		t.Errorf("unexpected result: %+v", got)
+	}
+
+	err = DecodeSource(HeaderSource(h), &got.Trace, &DecodeStringMapOptions{ErrorUnused: true, Prefix: "x-trace-"})
+	want = []string{"X-Trace-Idd"}
+	if names := decodeErrorNames(err); !reflect.DeepEqual(names, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, names)
+	}
+}
+
+func TestLayered(t *testing.T) {
+	type layeredTest struct {
+		Host  string
+		Port  int
+		Debug bool
+		Level int
+	}
+	src := Layered{
+		{Name: "defaults", Source: MapSource{"Host": "localhost", "Port": "80", "Level": "1"}},
+		{Name: "file", Source: MapSource{"Port": "8080", "Debug": "yes"}},
+		{Name: "flags", Source: Layered{
+			{Name: "short", Source: MapSource{"Level": "x"}},
+		}},
+		{Name: "lookup", Source: lookupSource(func(key string) (string, bool) {
+			if key == "Host" {
+				return "example.com", true
+			}
+			return "", false
+		})},
+	}
+
+	var got layeredTest
+	err := DecodeSource(src, &got, nil)
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) {
+		t.Fatalf("want *DecodeError, got %v", err)
+	}
+	wantErr := map[string]string{
+		"Debug": "supplied by the file layer",
+		"Level": "supplied by the flags/short layer",
+	}
+	if len(decErr.Detail) != len(wantErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, e := range decErr.Detail {
+		if msg := e.Messages[len(e.Messages)-1]; msg != wantErr[e.Name] {
+			t.Errorf("%v: want %q, got %q", e.Name, wantErr[e.Name], msg)
+		}
+	}
+	want := layeredTest{Host: "example.com", Port: 8080}
+	if got != want {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+
+	if keys, want := src.Keys(), []string{"Debug", "Host", "Level", "Port"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, keys)
+	}
+}
+
+func TestLayeredFileSuffix(t *testing.T) {
+	type layeredTest struct {
+		Password string
+	}
+	src := Layered{
+		{Name: "env", Source: MapSource{"Password_FILE": "/nonexistent/password"}},
+	}
+	var got layeredTest
+	err := DecodeSource(src, &got, &DecodeStringMapOptions{FileSuffix: "_FILE"})
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) || len(decErr.Detail) != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e := decErr.Detail[0]
+	if e.Name != "Password_FILE" || e.Messages[len(e.Messages)-1] != "supplied by the env layer" {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
+
+func TestDecodeSourceValues(t *testing.T) {
+	type valuesTest struct {
+		IDs  []int `strmap:"x-id"`
+		Name string
+	}
+	want := valuesTest{IDs: []int{1, 2, 3}, Name: "a"}
+
+	tests := []struct {
+		name string
+		in   Source
+	}{
+		{
+			name: "values",
+			in:   ValuesSource(url.Values{"x-id": {"1", "2", "3"}, "Name": {"a", "b"}}),
+		},
+		{
+			name: "header",
+			in:   HeaderSource(http.Header{"X-Id": {"1", "2", "3"}, "Name": {"a", "b"}}),
+		},
+		{
+			name: "layered",
+			in: Layered{
+				{Name: "values", Source: ValuesSource(url.Values{"x-id": {"1", "2", "3"}})},
+				{Name: "map", Source: MapSource{"Name": "a"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got valuesTest
+			if err := DecodeSource(tt.in, &got, nil); err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeSourceValuesExpand(t *testing.T) {
+	type valuesTest struct {
+		IDs   []int    `strmap:"id"`
+		Cycle []string `strmap:"cycle"`
+	}
+	src := ValuesSource(url.Values{
+		"id":    {"${base}1", "${base}2"},
+		"base":  {"1"},
+		"cycle": {"a", "${cycle}"},
+	})
+
+	var got valuesTest
+	err := DecodeSource(src, &got, &DecodeStringMapOptions{Expand: true})
+	if names := decodeErrorNames(err); !reflect.DeepEqual(names, []string{"cycle"}) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []int{11, 12}; !reflect.DeepEqual(got.IDs, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got.IDs)
+	}
+}
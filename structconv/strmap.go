@@ -5,26 +5,82 @@
 package structconv
 
 import (
+	"encoding"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	stringMapTagName          = "strmap"
 	msgDetailInvalidFieldType = "%v most be %v"
+	msgDetailTooManyElements  = "%v must have at most %v elements"
+	msgDetailInvalidPair      = "%v must be in the form key%vvalue"
+	msgDetailInvalidDefault   = "%v has an invalid default value"
+	msgDetailDefaultType      = "%v of type %v cannot have a default value"
+	msgDetailReadFile         = "cannot read the file %v"
+	defaultSep                = ","
+	defaultKVSep              = "="
+)
+
+// StringUnmarshaler is the interface implemented by types that can decode
+// a string representation of themselves.
+// It takes precedence over encoding.TextUnmarshaler in DecodeStringMap.
+type StringUnmarshaler interface {
+	UnmarshalString(s string) error
+}
+
+var (
+	stringUnmarshalerType = reflect.TypeOf((*StringUnmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	durationType          = reflect.TypeOf(time.Duration(0))
+	timeType              = reflect.TypeOf(time.Time{})
 )
 
 type DecodeStringMapOptions struct {
 	TagName      string
 	TagOnly      bool
 	KeyConverter func(string) string
+	// ErrorUnused reports the keys of the map that are not decoded
+	// into any field.
+	ErrorUnused bool
+	// Metadata, if not nil, receives the information about the decoding.
+	// It is ignored by Decoder.
+	Metadata *Metadata
+	// Prefix is prepended to all the keys.
+	// The prefix tag option of the nested structs is appended to it.
+	Prefix string
+	// FileSuffix, if not empty, reads the value of a missing key from
+	// the file named by the key with the suffix, such as "_FILE".
+	FileSuffix string
+	// Expand interpolates ${NAME} and ${NAME:-fallback} in the values
+	// with the other values of the map before conversion.
+	// The fallback is used if NAME is missing or empty, and $$ means $.
+	Expand bool
+}
+
+type EncodeStringMapOptions struct {
+	TagName      string
+	TagOnly      bool
+	KeyConverter func(string) string
+	// Prefix is prepended to all the keys.
+	// The prefix tag option of the nested structs is appended to it.
+	Prefix string
 }
 
 type stringMapToStructParams struct {
-	Struct    reflect.Value
-	StringMap map[string]string
-	Options   DecodeStringMapOptions
+	Struct   reflect.Value
+	Source   Source
+	Options  DecodeStringMapOptions
+	Cache    *fieldCache
+	Known    map[string]bool
+	Expander *expander
 }
 
 func nilKeyConverter(s string) string { return s }
@@ -32,17 +88,38 @@ func nilKeyConverter(s string) string { return s }
 // DecodeStringMap decodes a string map into a struct.
 func DecodeStringMap(m map[string]string, v interface{}, o *DecodeStringMapOptions) error {
 	opts := initDecodeStringMapOptions(o)
+	return decodeSource(MapSource(m), v, opts, nil)
+}
+
+func decodeSource(src Source, v interface{}, opts DecodeStringMapOptions, c *fieldCache) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &DecodeError{
+				Detail: []*DecodeFieldError{newPanicFieldError("", r)},
+			}
+		}
+	}()
+
 	s, err := checkStructPtr(v)
 	if err != nil {
 		return err
 	}
+	if _, ok := src.(KeysSource); opts.ErrorUnused && !ok {
+		return errSourceKeys
+	}
 	if err := initStruct(v); err != nil {
 		return err
 	}
+	opts.Metadata.reset()
 	params := stringMapToStructParams{
-		Struct:    s,
-		StringMap: m,
-		Options:   opts,
+		Struct:  s,
+		Source:  src,
+		Options: opts,
+		Cache:   c,
+		Known:   map[string]bool{},
+	}
+	if opts.Expand {
+		params.Expander = newExpander(src)
 	}
 	if err := stringMapToStruct(params); err != nil {
 		return err
@@ -65,7 +142,11 @@ func initDecodeStringMapOptions(o *DecodeStringMapOptions) DecodeStringMapOption
 }
 
 func stringMapToStruct(params stringMapToStructParams) *DecodeError {
-	if errs := doStringMapToStruct(params); len(errs) > 0 {
+	errs := doStringMapToStruct(params)
+	if params.Options.ErrorUnused || params.Options.Metadata != nil {
+		errs = append(errs, unusedStringMapKeys(params)...)
+	}
+	if len(errs) > 0 {
 		err := &DecodeError{
 			Detail: errs,
 		}
@@ -76,28 +157,39 @@ func stringMapToStruct(params stringMapToStructParams) *DecodeError {
 
 func doStringMapToStruct(params stringMapToStructParams) []*DecodeFieldError {
 	var errs []*DecodeFieldError
-	walkStructFields(params.Struct, func(inf fieldInfo) {
+	walkStructFields(params.Cache, params.Struct, params.Options.TagName, func(inf fieldInfo) {
+		fieldName := inf.Meta.Name
+		defer func() {
+			if r := recover(); r != nil {
+				errs = append(errs, newPanicFieldError(fieldName, r))
+			}
+		}()
+
 		if len(inf.Collections) > 0 {
 			return
 		}
-		if inf.ChildOK {
+		if inf.ChildOK && !inf.Unmarshaler {
 			p := stringMapToStructParams{
-				Struct:    inf.Child,
-				StringMap: params.StringMap,
-				Options:   params.Options,
+				Struct:   inf.Child,
+				Source:   params.Source,
+				Options:  params.Options,
+				Cache:    params.Cache,
+				Known:    params.Known,
+				Expander: params.Expander,
 			}
+			p.Options.Prefix += inf.Tag.Prefix
 			childErrs := doStringMapToStruct(p)
 			if len(childErrs) > 0 {
 				errs = append(errs, childErrs...)
 			}
 			return
 		}
-		tag, err := parseDecodeTag(inf.Meta, params.Options.TagName)
-		if err != nil {
+		tag := inf.Tag
+		if inf.TagErr != nil {
 			decErr := &DecodeFieldError{
 				Name: inf.Meta.Name,
 				Messages: []string{
-					err.Error(),
+					inf.TagErr.Error(),
 				},
 			}
 			errs = append(errs, decErr)
@@ -110,30 +202,175 @@ func doStringMapToStruct(params stringMapToStructParams) []*DecodeFieldError {
 			return
 		}
 
-		key := getStringMapKey(inf, tag, params.Options.KeyConverter)
-		if val, ok := params.StringMap[key]; ok {
-			if err := convertStringToField(inf.Meta, inf.Value, val); err != nil {
-				typ := inf.Value.Type().String()
-				msg := fmt.Sprintf(msgDetailInvalidFieldType, key, typ)
-				err := &DecodeFieldError{
-					Name:     key,
-					Value:    val,
-					Messages: []string{msg},
-				}
-				errs = append(errs, err)
+		key := params.Options.Prefix + getStringMapKey(inf, tag, params.Options.KeyConverter)
+		fieldName = key
+		params.Known[key] = true
+		if params.Options.FileSuffix != "" {
+			params.Known[key+params.Options.FileSuffix] = true
+		}
+		meta := params.Options.Metadata
+		vals, srcKey, lookupErr := lookupStringMap(params, key, inf.Value, tag)
+		if lookupErr != nil {
+			errs = append(errs, withSourceName(params.Source, srcKey, lookupErr)...)
+			return
+		}
+		if srcKey != "" {
+			if e := convertValuesToField(key, inf.Value, vals, tag); len(e) > 0 {
+				errs = append(errs, withSourceName(params.Source, srcKey, e...)...)
+			} else {
+				meta.addSet(key)
+			}
+		} else if tag.HasDefault {
+			if e := convertDefaultToField(key, inf.Value, tag); len(e) > 0 {
+				errs = append(errs, e...)
+			} else {
+				meta.addDefaulted(key)
 			}
 		} else if tag.Required {
 			err := &DecodeFieldError{
 				Name:     key,
-				Value:    val,
 				Messages: []string{fmt.Sprintf(msgDetailRequired, key)},
 			}
 			errs = append(errs, err)
+			meta.addMissing(key)
+		} else {
+			meta.addUnset(key)
 		}
 	})
 	return errs
 }
 
+// lookupStringMap returns the values of the key in the source,
+// and the key of the source supplying them, or "" if the key is missing.
+// If the key is missing, the value is read from the file named by the key
+// with FileSuffix. If the field has the file tag option, the value is
+// the name of the file to read.
+// The source has multiple values only for the keys of collections and maps
+// in the sources that support them, such as query parameters.
+func lookupStringMap(params stringMapToStructParams, key string, rv reflect.Value, tag decodeTagInfo) ([]string, string, *DecodeFieldError) {
+	if vals, ok := lookupStringMapValues(params.Source, key); ok && len(vals) > 1 && !tag.File && isMultiValueType(rv.Type()) {
+		vals, err := expandStringMapValues(params, key, vals)
+		return vals, key, err
+	}
+	if val, ok := params.Source.Lookup(key); ok {
+		val, err := expandStringMapValue(params, key, val)
+		if err == nil && tag.File {
+			val, err = readFileValue(key, val)
+		}
+		return []string{val}, key, err
+	}
+	suffix := params.Options.FileSuffix
+	if suffix == "" {
+		return nil, "", nil
+	}
+	if path, ok := params.Source.Lookup(key + suffix); ok {
+		path, err := expandStringMapValue(params, key+suffix, path)
+		if err != nil {
+			return nil, key + suffix, err
+		}
+		val, err := readFileValue(key+suffix, path)
+		return []string{val}, key + suffix, err
+	}
+	return nil, "", nil
+}
+
+// lookupStringMapValues returns all the values of the key
+// if the source can have multiple values.
+func lookupStringMapValues(src Source, key string) ([]string, bool) {
+	vs, ok := src.(valuesSource)
+	if !ok {
+		return nil, false
+	}
+	return vs.lookupValues(key)
+}
+
+// isMultiValueType reports whether the type, following pointers,
+// takes all the values of a key.
+func isMultiValueType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return isStringCollectionType(t) || isStringMapType(t)
+}
+
+// expandStringMapValue returns the value of the key,
+// expanding the references in it if Expand is set.
+func expandStringMapValue(params stringMapToStructParams, key, val string) (string, *DecodeFieldError) {
+	if params.Expander == nil {
+		return val, nil
+	}
+	v, err := params.Expander.expandKey(key, nil)
+	if err != nil {
+		return "", &DecodeFieldError{
+			Name:     key,
+			Value:    val,
+			Messages: []string{err.Error()},
+		}
+	}
+	return v, nil
+}
+
+// expandStringMapValues returns all the values of the key,
+// expanding the references in each of them if Expand is set.
+func expandStringMapValues(params stringMapToStructParams, key string, vals []string) ([]string, *DecodeFieldError) {
+	if params.Expander == nil {
+		return vals, nil
+	}
+	result := make([]string, len(vals))
+	for i, val := range vals {
+		v, err := params.Expander.expandValue(key, val)
+		if err != nil {
+			return nil, &DecodeFieldError{
+				Name:     key,
+				Value:    val,
+				Messages: []string{err.Error()},
+			}
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// readFileValue reads the file as a value.
+// A trailing newline is trimmed as written by most editors and tools.
+func readFileValue(name, path string) (string, *DecodeFieldError) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", &DecodeFieldError{
+			Name:     name,
+			Value:    path,
+			Messages: []string{fmt.Sprintf(msgDetailReadFile, path), err.Error()},
+		}
+	}
+	s := strings.TrimSuffix(string(b), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+// unusedStringMapKeys records the keys of the source
+// that are not known to the struct, and reports them if ErrorUnused is set.
+// The keys are unknown if the source does not implement KeysSource.
+func unusedStringMapKeys(params stringMapToStructParams) []*DecodeFieldError {
+	src, ok := params.Source.(KeysSource)
+	if !ok {
+		return nil
+	}
+	known := make([]string, 0, len(params.Known))
+	for k := range params.Known {
+		known = append(known, k)
+	}
+	sort.Strings(known)
+
+	var errs []*DecodeFieldError
+	for _, k := range unknownKeys(src, params.Known, params.Options.Prefix) {
+		params.Options.Metadata.addUnused(k)
+		if params.Options.ErrorUnused {
+			v, _ := src.Lookup(k)
+			errs = append(errs, newUnusedFieldError(k, k, v, known))
+		}
+	}
+	return errs
+}
+
 func getStringMapKey(info fieldInfo, tag decodeTagInfo, fn func(string) string) string {
 	var key string
 	if tag.OK && tag.Key != "" {
@@ -144,31 +381,247 @@ func getStringMapKey(info fieldInfo, tag decodeTagInfo, fn func(string) string)
 	return key
 }
 
-func convertStringToField(rf reflect.StructField, rv reflect.Value, in string) error {
-	crt := rf.Type
-	crv := rv
-	var rootPtr *reflect.Value
-	for isRoot := true; crt.Kind() == reflect.Ptr; {
-		ptr := reflect.New(crt.Elem())
-		if isRoot {
-			rootPtr = &ptr
-			isRoot = false
-		} else {
-			crv.Set(ptr)
+// convertStringToField converts the string into the field.
+// The field is left unchanged if the conversion fails,
+// or if the field type is not supported.
+func convertStringToField(name string, rv reflect.Value, in string, tag decodeTagInfo) []*DecodeFieldError {
+	return convertValuesToField(name, rv, []string{in}, tag)
+}
+
+// convertValuesToField converts the values of a key into the field
+// in the same way as convertStringToField.
+// A single value of a collection or a map is split by the separator,
+// while multiple values are taken as the elements or the pairs as is.
+// The other fields take the first value.
+func convertValuesToField(name string, rv reflect.Value, in []string, tag decodeTagInfo) []*DecodeFieldError {
+	if !isStringConvertibleType(rv.Type(), true) {
+		return nil
+	}
+	v := reflect.New(rv.Type()).Elem()
+	cv := allocPtr(v)
+	var errs []*DecodeFieldError
+	switch {
+	case isStringCollectionType(cv.Type()):
+		errs = convertStringToCollection(name, cv, splitValues(in, tag), tag)
+	case isStringMapType(cv.Type()):
+		errs = convertStringToMap(name, cv, splitValues(in, tag), tag)
+	default:
+		errs = convertStringToScalar(name, cv, in[0], tag)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	rv.Set(v)
+	return nil
+}
+
+// convertDefaultToField converts the default value of the tag into the field.
+// It fails if the field type cannot be converted from a string.
+func convertDefaultToField(name string, rv reflect.Value, tag decodeTagInfo) []*DecodeFieldError {
+	if !isStringConvertibleType(rv.Type(), true) {
+		return []*DecodeFieldError{{
+			Name:     name,
+			Value:    tag.Default,
+			Messages: []string{fmt.Sprintf(msgDetailDefaultType, name, rv.Type())},
+		}}
+	}
+	errs := convertStringToField(name, rv, tag.Default, tag)
+	for _, e := range errs {
+		e.Messages = append(e.Messages, fmt.Sprintf(msgDetailInvalidDefault, name))
+	}
+	return errs
+}
+
+// splitValues returns the elements of the values.
+// A single value is split by the separator, and an empty one has no elements.
+func splitValues(in []string, tag decodeTagInfo) []string {
+	if len(in) != 1 {
+		return in
+	}
+	if in[0] == "" {
+		return nil
+	}
+	return strings.Split(in[0], tagSep(tag))
+}
+
+func convertStringToCollection(name string, rv reflect.Value, elems []string, tag decodeTagInfo) []*DecodeFieldError {
+	switch rv.Kind() {
+	case reflect.Array:
+		if len(elems) > rv.Len() {
+			return []*DecodeFieldError{{
+				Name:     name,
+				Value:    strings.Join(elems, tagSep(tag)),
+				Messages: []string{fmt.Sprintf(msgDetailTooManyElements, name, rv.Len())},
+			}}
 		}
-		crt = crt.Elem()
-		crv = ptr.Elem()
+	case reflect.Slice:
+		rv.Set(reflect.MakeSlice(rv.Type(), len(elems), len(elems)))
 	}
-	if err := doConvertStringToField(crv, in); err != nil {
-		return err
+
+	var errs []*DecodeFieldError
+	for i, e := range elems {
+		newName := name + "[" + fmt.Sprint(i) + "]"
+		ev := allocPtr(rv.Index(i))
+		if e := convertStringToScalar(newName, ev, e, tag); len(e) > 0 {
+			errs = append(errs, e...)
+		}
 	}
-	if rootPtr != nil {
-		rv.Set(*rootPtr)
+	return errs
+}
+
+func convertStringToMap(name string, rv reflect.Value, pairs []string, tag decodeTagInfo) []*DecodeFieldError {
+	m := reflect.MakeMapWithSize(rv.Type(), len(pairs))
+	kvSep := tagKVSep(tag)
+
+	var errs []*DecodeFieldError
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, kvSep, 2)
+		if len(kv) != 2 {
+			errs = append(errs, &DecodeFieldError{
+				Name:     name,
+				Value:    pair,
+				Messages: []string{fmt.Sprintf(msgDetailInvalidPair, pair, kvSep)},
+			})
+			continue
+		}
+		newName := name + "[" + kv[0] + "]"
+		k := reflect.New(rv.Type().Key()).Elem()
+		if e := convertStringToScalar(newName, allocPtr(k), kv[0], tag); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+		v := reflect.New(rv.Type().Elem()).Elem()
+		if e := convertStringToScalar(newName, allocPtr(v), kv[1], tag); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+		m.SetMapIndex(k, v)
 	}
+	if len(errs) > 0 {
+		return errs
+	}
+	rv.Set(m)
 	return nil
 }
 
-func doConvertStringToField(rv reflect.Value, s string) error {
+func convertStringToScalar(name string, rv reflect.Value, in string, tag decodeTagInfo) []*DecodeFieldError {
+	if err := doConvertStringToField(rv, in, tag); err != nil {
+		msg := fmt.Sprintf(msgDetailInvalidFieldType, name, rv.Type())
+		return []*DecodeFieldError{{
+			Name:     name,
+			Value:    in,
+			Messages: []string{msg, err.Error()},
+		}}
+	}
+	return nil
+}
+
+// allocPtr allocates the nil pointers of rv,
+// and returns the value that the pointers point to.
+func allocPtr(rv reflect.Value) reflect.Value {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+// isStringConvertibleType reports whether the type, following pointers,
+// can be converted from a string.
+// Collections and maps of scalars are also supported if collection is true.
+func isStringConvertibleType(t reflect.Type, collection bool) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if collection && isStringCollectionType(t) {
+		return isStringConvertibleType(t.Elem(), false)
+	}
+	if collection && isStringMapType(t) {
+		return isStringConvertibleType(t.Key(), false) &&
+			isStringConvertibleType(t.Elem(), false)
+	}
+	if t == durationType || t == timeType || isUnmarshalerType(t) || isBytesType(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isStringCollectionType reports whether the type is a slice or an array
+// whose elements are converted from a separator-delimited string.
+func isStringCollectionType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array, reflect.Slice:
+		return !isUnmarshalerType(t) && !isMarshalerType(t) && !isBytesType(t)
+	}
+	return false
+}
+
+// isStringMapType reports whether the type is a map whose entries are
+// converted from a string of delimited key/value pairs.
+func isStringMapType(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && !isUnmarshalerType(t) && !isMarshalerType(t)
+}
+
+func isBytesType(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+// tagSep returns the separator of the collection elements,
+// which defaults to a comma.
+func tagSep(tag decodeTagInfo) string {
+	if tag.Sep != "" {
+		return tag.Sep
+	}
+	return defaultSep
+}
+
+// tagKVSep returns the separator between the key and the value of
+// the map entries, which defaults to an equal sign.
+func tagKVSep(tag decodeTagInfo) string {
+	if tag.KVSep != "" {
+		return tag.KVSep
+	}
+	return defaultKVSep
+}
+
+func doConvertStringToField(rv reflect.Value, s string, tag decodeTagInfo) error {
+	switch rv.Type() {
+	case durationType:
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		rv.SetInt(int64(v))
+		return nil
+	case timeType:
+		v, err := time.Parse(timeLayout(tag), s)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if rv.CanAddr() {
+		switch u := rv.Addr().Interface().(type) {
+		case StringUnmarshaler:
+			return u.UnmarshalString(s)
+		case encoding.TextUnmarshaler:
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+	if isBytesType(rv.Type()) {
+		rv.SetBytes([]byte(s))
+		return nil
+	}
 	switch rv.Type().Kind() {
 	case reflect.String:
 		rv.SetString(s)
@@ -199,3 +652,236 @@ func doConvertStringToField(rv reflect.Value, s string) error {
 	}
 	return nil
 }
+
+// EncodeStringMap encodes a struct into a string map.
+// Nested structs are flattened in the same way as DecodeStringMap reads them.
+// Types implementing encoding.TextMarshaler are encoded by MarshalText.
+func EncodeStringMap(v interface{}, o *EncodeStringMapOptions) (map[string]string, error) {
+	opts := initEncodeStringMapOptions(o)
+	s, err := checkStruct(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]string{}
+	err = structToStringMap(s, opts, func(key string, rv reflect.Value, tag decodeTagInfo) error {
+		val, ok, err := convertFieldToString(rv, tag)
+		if ok {
+			m[key] = val
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func encodeValues(v interface{}, o *EncodeStringMapOptions) (url.Values, error) {
+	opts := initEncodeStringMapOptions(o)
+	s, err := checkStruct(v)
+	if err != nil {
+		return nil, err
+	}
+	u := url.Values{}
+	err = structToStringMap(s, opts, func(key string, rv reflect.Value, tag decodeTagInfo) error {
+		vals, _, err := convertFieldToStrings(rv, tag)
+		for _, val := range vals {
+			u.Add(key, val)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func initEncodeStringMapOptions(o *EncodeStringMapOptions) EncodeStringMapOptions {
+	var result EncodeStringMapOptions
+	if o != nil {
+		result = *o
+	}
+	if result.TagName == "" {
+		result.TagName = stringMapTagName
+	}
+	if result.KeyConverter == nil {
+		result.KeyConverter = nilKeyConverter
+	}
+	return result
+}
+
+// structToStringMap walks the struct, calling setFn for each field
+// to be encoded with its string map key.
+func structToStringMap(s reflect.Value, o EncodeStringMapOptions, setFn func(key string, rv reflect.Value, tag decodeTagInfo) error) error {
+	var err error
+	walkStructFields(nil, s, o.TagName, func(inf fieldInfo) {
+		if err != nil {
+			return
+		}
+		if len(inf.Collections) > 0 {
+			return
+		}
+		if inf.ChildOK && !inf.Marshaler {
+			co := o
+			co.Prefix += inf.Tag.Prefix
+			err = structToStringMap(inf.Child, co, setFn)
+			return
+		}
+		tag := inf.Tag
+		if inf.TagErr != nil {
+			err = inf.TagErr
+			return
+		}
+		if tag.Omitted {
+			return
+		}
+		if o.TagOnly && !tag.OK {
+			return
+		}
+		if tag.OmitEmpty && isEmptyValue(inf.Value) {
+			return
+		}
+
+		key := o.Prefix + getStringMapKey(inf, tag, o.KeyConverter)
+		if e := setFn(key, inf.Value, tag); e != nil {
+			err = fmt.Errorf("structconv: %v: %w", key, e)
+		}
+	})
+	return err
+}
+
+// convertFieldToString converts the field into a string.
+// The elements of a slice or an array are joined with the separator.
+// It returns false if the field is a nil pointer or an unsupported type.
+func convertFieldToString(rv reflect.Value, tag decodeTagInfo) (string, bool, error) {
+	vals, ok, err := convertFieldToStrings(rv, tag)
+	if !ok {
+		return "", false, err
+	}
+	t := rv.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isStringCollectionType(t) || isStringMapType(t) {
+		return strings.Join(vals, tagSep(tag)), true, nil
+	}
+	return vals[0], true, nil
+}
+
+// convertFieldToStrings converts the field into strings,
+// one for each element if the field is a slice or an array,
+// and one for each key/value pair sorted by key if the field is a map.
+func convertFieldToStrings(rv reflect.Value, tag decodeTagInfo) ([]string, bool, error) {
+	crv, ok := followPtr(rv)
+	if !ok {
+		return nil, false, nil
+	}
+	if isStringMapType(crv.Type()) {
+		return convertMapToStrings(crv, tag)
+	}
+	if !isStringCollectionType(crv.Type()) {
+		s, ok, err := doConvertFieldToString(crv, tag)
+		if !ok {
+			return nil, false, err
+		}
+		return []string{s}, true, nil
+	}
+	result := make([]string, 0, crv.Len())
+	for i := 0; i < crv.Len(); i++ {
+		ev, ok := followPtr(crv.Index(i))
+		if !ok {
+			return nil, false, nil
+		}
+		s, ok, err := doConvertFieldToString(ev, tag)
+		if !ok {
+			return nil, false, err
+		}
+		result = append(result, s)
+	}
+	return result, true, nil
+}
+
+func convertMapToStrings(rv reflect.Value, tag decodeTagInfo) ([]string, bool, error) {
+	result := make([]string, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		kv, ok := followPtr(iter.Key())
+		if !ok {
+			return nil, false, nil
+		}
+		k, ok, err := doConvertFieldToString(kv, tag)
+		if !ok {
+			return nil, false, err
+		}
+		vv, ok := followPtr(iter.Value())
+		if !ok {
+			return nil, false, nil
+		}
+		v, ok, err := doConvertFieldToString(vv, tag)
+		if !ok {
+			return nil, false, err
+		}
+		result = append(result, k+tagKVSep(tag)+v)
+	}
+	sort.Strings(result)
+	return result, true, nil
+}
+
+func followPtr(rv reflect.Value) (reflect.Value, bool) {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return rv, false
+		}
+		rv = rv.Elem()
+	}
+	return rv, true
+}
+
+func doConvertFieldToString(rv reflect.Value, tag decodeTagInfo) (string, bool, error) {
+	switch rv.Type() {
+	case durationType:
+		return time.Duration(rv.Int()).String(), true, nil
+	case timeType:
+		layout := tag.Layout
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		return rv.Interface().(time.Time).Format(layout), true, nil
+	}
+	if isBytesType(rv.Type()) && !isMarshalerType(rv.Type()) {
+		return string(rv.Bytes()), true, nil
+	}
+	m, ok := rv.Interface().(encoding.TextMarshaler)
+	if !ok && rv.CanAddr() {
+		m, ok = rv.Addr().Interface().(encoding.TextMarshaler)
+	}
+	if ok {
+		b, err := m.MarshalText()
+		if err != nil {
+			return "", false, err
+		}
+		return string(b), true, nil
+	}
+	switch rv.Type().Kind() {
+	case reflect.String:
+		return rv.String(), true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), true, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits()), true, nil
+	}
+	return "", false, nil
+}
+
+// timeLayout returns the layout for parsing time.Time,
+// which defaults to RFC 3339.
+func timeLayout(tag decodeTagInfo) string {
+	if tag.Layout != "" {
+		return tag.Layout
+	}
+	return time.RFC3339
+}
@@ -5,8 +5,16 @@
 package structconv
 
 import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDecodeStringMap(t *testing.T) {
@@ -79,3 +87,842 @@ func TestDecodeStringMap(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeStringMap(t *testing.T) {
+	type testNestedStringMap1 struct {
+		N1 int
+	}
+	type testNestedStringMap2 struct {
+		N2 uint8
+	}
+	type testStringMap struct {
+		String   string
+		Bool     bool
+		Int      int
+		Uint     uint
+		Float32  float32
+		Float64  float64
+		Ptr      *int
+		NilPtr   *int
+		Rename   string `strmap:"alt_key"`
+		Omitted  string `strmap:"-"`
+		Nest11   testNestedStringMap1
+		Nest12   *testNestedStringMap2
+		Nest2    []testNestedStringMap2
+		Labels   map[string]string
+		Ignored  chan int
+		Untagged string
+	}
+
+	ptr := -2
+	tests := []struct {
+		name string
+		in   testStringMap
+		opts *EncodeStringMapOptions
+		want map[string]string
+	}{
+		{
+			"normal",
+			testStringMap{
+				String:   "str",
+				Bool:     true,
+				Int:      -1,
+				Uint:     1,
+				Float32:  0.1,
+				Float64:  0.3,
+				Ptr:      &ptr,
+				Rename:   "alt",
+				Omitted:  "-",
+				Nest11:   testNestedStringMap1{N1: 3},
+				Nest12:   &testNestedStringMap2{N2: 4},
+				Nest2:    []testNestedStringMap2{{N2: 5}},
+				Labels:   map[string]string{"a": "b"},
+				Ignored:  make(chan int),
+				Untagged: "u",
+			},
+			nil,
+			map[string]string{
+				"String":   "str",
+				"Bool":     "true",
+				"Int":      "-1",
+				"Uint":     "1",
+				"Float32":  "0.1",
+				"Float64":  "0.3",
+				"Ptr":      "-2",
+				"alt_key":  "alt",
+				"N1":       "3",
+				"N2":       "4",
+				"Labels":   "a=b",
+				"Untagged": "u",
+			},
+		},
+		{
+			"tag only and key converter",
+			testStringMap{
+				Rename:   "alt",
+				Untagged: "u",
+				Nest11:   testNestedStringMap1{N1: 3},
+			},
+			&EncodeStringMapOptions{
+				TagOnly:      true,
+				KeyConverter: strings.ToLower,
+			},
+			map[string]string{
+				"alt_key": "alt",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := EncodeStringMap(tt.in, tt.opts)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+
+			var decoded testStringMap
+			opts := &DecodeStringMapOptions{}
+			if tt.opts != nil {
+				opts.TagOnly = tt.opts.TagOnly
+				opts.KeyConverter = tt.opts.KeyConverter
+			}
+			if err := DecodeStringMap(got, &decoded, opts); err != nil {
+				t.Error(err)
+			}
+			again, err := EncodeStringMap(decoded, tt.opts)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(again, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, again)
+			}
+		})
+	}
+}
+
+// testPanicUnmarshaler panics in decoding to test the recovery.
+type testPanicUnmarshaler struct{}
+
+func (*testPanicUnmarshaler) UnmarshalString(s string) error {
+	panic("unmarshal " + s)
+}
+
+func TestDecodeStringMapRecoverPanic(t *testing.T) {
+	type testPanic struct {
+		A testPanicUnmarshaler
+		B string
+	}
+
+	var got testPanic
+	err := DecodeStringMap(map[string]string{"A": "a", "B": "b"}, &got, nil)
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) || len(decErr.Detail) != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &DecodeFieldError{Name: "A", Messages: []string{"unexpected panic: unmarshal a"}}
+	if !reflect.DeepEqual(decErr.Detail[0], want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, decErr.Detail[0])
+	}
+	if got.B != "b" {
+		t.Errorf("the other fields must be decoded: %+v", got)
+	}
+}
+
+type testStringMapLevel int
+
+func (l *testStringMapLevel) UnmarshalString(s string) error {
+	switch s {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return errors.New("unknown level")
+	}
+	return nil
+}
+
+func (l testStringMapLevel) MarshalText() ([]byte, error) {
+	switch l {
+	case 1:
+		return []byte("low"), nil
+	case 2:
+		return []byte("high"), nil
+	}
+	return nil, errors.New("unknown level")
+}
+
+func TestDecodeStringMapUnmarshaler(t *testing.T) {
+	type testUnmarshaler struct {
+		IP       net.IP
+		IPPtr    *net.IP
+		Big      big.Int
+		BigPtr   *big.Int
+		NilBig   *big.Int
+		Level    testStringMapLevel
+		LevelPtr *testStringMapLevel
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]string
+		want    testUnmarshaler
+		wantErr []string
+	}{
+		{
+			"normal",
+			map[string]string{
+				"IP":       "192.0.2.1",
+				"IPPtr":    "2001:db8::1",
+				"Big":      "123456789012345678901234567890",
+				"BigPtr":   "-1",
+				"Level":    "high",
+				"LevelPtr": "low",
+			},
+			testUnmarshaler{
+				IP:       net.ParseIP("192.0.2.1"),
+				IPPtr:    func() *net.IP { ip := net.ParseIP("2001:db8::1"); return &ip }(),
+				Big:      *func() *big.Int { i, _ := new(big.Int).SetString("123456789012345678901234567890", 10); return i }(),
+				BigPtr:   big.NewInt(-1),
+				Level:    2,
+				LevelPtr: func() *testStringMapLevel { l := testStringMapLevel(1); return &l }(),
+			},
+			nil,
+		},
+		{
+			"error",
+			map[string]string{
+				"IP":    "x",
+				"Level": "middle",
+			},
+			testUnmarshaler{},
+			[]string{"IP", "Level"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testUnmarshaler
+			err := DecodeStringMap(tt.in, &got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+
+			m, err := EncodeStringMap(got, nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(m, tt.in) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.in, m)
+			}
+		})
+	}
+}
+
+func decodeErrorNames(err error) []string {
+	if err == nil {
+		return nil
+	}
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) {
+		return []string{err.Error()}
+	}
+	var names []string
+	for _, d := range decErr.Detail {
+		names = append(names, d.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDecodeStringMapErrorUnused(t *testing.T) {
+	type testUnusedDB struct {
+		Host string `strmap:"DB_HOST"`
+	}
+	type testUnused struct {
+		Name    string
+		Omitted string `strmap:"-"`
+		DB      testUnusedDB
+	}
+
+	tests := []struct {
+		name string
+		in   map[string]string
+		want []*DecodeFieldError
+	}{
+		{
+			name: "all used",
+			in:   map[string]string{"Name": "a", "DB_HOST": "b"},
+		},
+		{
+			name: "unused",
+			in: map[string]string{
+				"name":    "a",
+				"DB_HOTS": "b",
+				"Omitted": "c",
+				"Port":    "d",
+			},
+			want: []*DecodeFieldError{
+				{
+					Name:     "DB_HOTS",
+					Value:    "b",
+					Messages: []string{"DB_HOTS is not used", "did you mean DB_HOST?"},
+				},
+				{
+					Name:     "Omitted",
+					Value:    "c",
+					Messages: []string{"Omitted is not used"},
+				},
+				{
+					Name:     "Port",
+					Value:    "d",
+					Messages: []string{"Port is not used"},
+				},
+				{
+					Name:     "name",
+					Value:    "a",
+					Messages: []string{"name is not used", "did you mean Name?"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testUnused
+			err := DecodeStringMap(tt.in, &got, &DecodeStringMapOptions{ErrorUnused: true})
+			var detail []*DecodeFieldError
+			var decErr *DecodeError
+			if errors.As(err, &decErr) {
+				detail = decErr.Detail
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(detail, tt.want) {
+				t.Errorf("\nwant = %v\ngot  = %v", tt.want, detail)
+			}
+		})
+	}
+}
+
+func TestDecodeStringMapMetadata(t *testing.T) {
+	type testMetadataDB struct {
+		Host string `strmap:"DB_HOST"`
+		Port int    `strmap:"DB_PORT,default=5432"`
+	}
+	type testMetadata struct {
+		Name  string `strmap:",required"`
+		Level int    `strmap:",default=1"`
+		Unset string
+		Bad   int
+		DB    testMetadataDB
+	}
+
+	in := map[string]string{
+		"Level":   "2",
+		"Bad":     "x",
+		"DB_HOST": "a",
+		"Unknown": "b",
+	}
+	want := Metadata{
+		Set:       []string{"Level", "DB_HOST"},
+		Defaulted: []string{"DB_PORT"},
+		Unset:     []string{"Unset"},
+		Missing:   []string{"Name"},
+		Unused:    []string{"Unknown"},
+	}
+
+	var got testMetadata
+	var meta Metadata
+	err := DecodeStringMap(in, &got, &DecodeStringMapOptions{Metadata: &meta})
+	if names := decodeErrorNames(err); !reflect.DeepEqual(names, []string{"Bad", "Name"}) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, meta)
+	}
+}
+
+func TestDecodeStringMapPrefix(t *testing.T) {
+	type testPrefixDB struct {
+		Host string
+	}
+	type testPrefix struct {
+		Name string
+		DB   testPrefixDB `strmap:",prefix=DB_"`
+	}
+
+	in := map[string]string{
+		"APP_Name":     "a",
+		"APP_DB_Host":  "b",
+		"APP_DB_Hots":  "c",
+		"OTHER_Unused": "d",
+	}
+	want := testPrefix{Name: "a", DB: testPrefixDB{Host: "b"}}
+	wantErr := []string{"APP_DB_Hots"}
+
+	var got testPrefix
+	opts := &DecodeStringMapOptions{Prefix: "APP_", ErrorUnused: true}
+	err := DecodeStringMap(in, &got, opts)
+	if names := decodeErrorNames(err); !reflect.DeepEqual(names, wantErr) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", wantErr, names)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+}
+
+func TestDecodeStringMapFile(t *testing.T) {
+	type testFile struct {
+		Cert  string `strmap:",file"`
+		Port  int    `strmap:",file"`
+		Plain string
+	}
+
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "cert")
+	if err := ioutil.WriteFile(cert, []byte("line1\nline2\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	port := filepath.Join(dir, "port")
+	if err := ioutil.WriteFile(port, []byte("8080\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		in      map[string]string
+		want    testFile
+		wantErr []string
+	}{
+		{
+			name: "normal",
+			in:   map[string]string{"Cert": cert, "Port": port, "Plain": cert},
+			want: testFile{Cert: "line1\nline2", Port: 8080, Plain: cert},
+		},
+		{
+			name:    "unreadable",
+			in:      map[string]string{"Cert": filepath.Join(dir, "missing"), "Port": cert},
+			wantErr: []string{"Cert", "Port"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testFile
+			err := DecodeStringMap(tt.in, &got, nil)
+			if names := decodeErrorNames(err); !reflect.DeepEqual(names, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, names)
+			}
+			if got != tt.want {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeStringMapExpand(t *testing.T) {
+	type testExpand struct {
+		URL   string `strmap:"DATABASE_URL"`
+		Port  int    `strmap:"PORT"`
+		Bad   string `strmap:"BAD"`
+		Cycle string `strmap:"CYCLE"`
+	}
+
+	in := map[string]string{
+		"DATABASE_URL": "postgres://${DB_USER}@${DB_HOST:-localhost}/app",
+		"DB_USER":      "alice",
+		"PORT":         "${BASE_PORT}0",
+		"BASE_PORT":    "808",
+		"BAD":          "${UNDEFINED}",
+		"CYCLE":        "${CYCLE}",
+	}
+	want := testExpand{URL: "postgres://alice@localhost/app", Port: 8080}
+	wantErr := []string{"BAD", "CYCLE"}
+
+	var got testExpand
+	err := DecodeStringMap(in, &got, &DecodeStringMapOptions{Expand: true})
+	if names := decodeErrorNames(err); !reflect.DeepEqual(names, wantErr) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", wantErr, names)
+	}
+	if got != want {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+}
+
+func TestDecodeStringMapTime(t *testing.T) {
+	type testTime struct {
+		Timeout     time.Duration
+		TimeoutPtr  *time.Duration
+		Start       time.Time
+		StartPtr    *time.Time
+		Date        time.Time `strmap:",layout=2006-01-02"`
+		RFC1123     time.Time `strmap:",layout=Mon, 02 Jan 2006 15:04:05 MST"`
+		US          time.Time `strmap:",layout=Jan 2, 2006,required"`
+		NotInSource *time.Time
+	}
+
+	timeout := 90 * time.Second
+	start := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	tests := []struct {
+		name    string
+		in      map[string]string
+		want    testTime
+		wantErr []string
+	}{
+		{
+			"normal",
+			map[string]string{
+				"Timeout":    "30s",
+				"TimeoutPtr": "1m30s",
+				"Start":      "2020-01-02T03:04:05.000000006Z",
+				"StartPtr":   "2020-01-02T03:04:05.000000006Z",
+				"Date":       "2021-12-31",
+				"RFC1123":    "Thu, 02 Jan 2020 03:04:05 UTC",
+				"US":         "Dec 31, 2021",
+			},
+			testTime{
+				Timeout:    30 * time.Second,
+				TimeoutPtr: &timeout,
+				Start:      start,
+				StartPtr:   &start,
+				Date:       time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC),
+				RFC1123:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+				US:         time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC),
+			},
+			nil,
+		},
+		{
+			"error",
+			map[string]string{
+				"Timeout": "30",
+				"Start":   "2020-01-02",
+				"Date":    "2020-01-02T03:04:05Z",
+			},
+			testTime{},
+			[]string{"Date", "Start", "Timeout", "US"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testTime
+			err := DecodeStringMap(tt.in, &got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+
+			m, err := EncodeStringMap(got, nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(m, tt.in) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.in, m)
+			}
+		})
+	}
+}
+
+func TestDecodeStringMapCollection(t *testing.T) {
+	type testCollection struct {
+		Hosts    []string `strmap:"HOSTS,sep=;"`
+		Ints     []int
+		Ptrs     []*int
+		SlicePtr *[]uint16 `strmap:",sep= "`
+		Array    [3]float64
+		Bytes    []byte
+		IPs      []net.IP
+		Timeouts []time.Duration
+		Empty    []string
+	}
+
+	one, two := 1, 2
+	tests := []struct {
+		name    string
+		in      map[string]string
+		want    testCollection
+		wantErr []string
+	}{
+		{
+			"normal",
+			map[string]string{
+				"HOSTS":    "a;b,c;d",
+				"Ints":     "1,-2,3",
+				"Ptrs":     "1,2",
+				"SlicePtr": "4 5",
+				"Array":    "0.5,1.5",
+				"Bytes":    "raw,bytes",
+				"IPs":      "192.0.2.1,192.0.2.2",
+				"Timeouts": "1s,2m0s",
+				"Empty":    "",
+			},
+			testCollection{
+				Hosts:    []string{"a", "b,c", "d"},
+				Ints:     []int{1, -2, 3},
+				Ptrs:     []*int{&one, &two},
+				SlicePtr: &[]uint16{4, 5},
+				Array:    [3]float64{0.5, 1.5},
+				Bytes:    []byte("raw,bytes"),
+				IPs:      []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")},
+				Timeouts: []time.Duration{time.Second, 2 * time.Minute},
+				Empty:    []string{},
+			},
+			nil,
+		},
+		{
+			"error",
+			map[string]string{
+				"Ints":  "1,x,3,y",
+				"Array": "1,2,3,4",
+			},
+			testCollection{},
+			[]string{"Array", "Ints[1]", "Ints[3]"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testCollection
+			err := DecodeStringMap(tt.in, &got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeStringMapCollection(t *testing.T) {
+	type testCollection struct {
+		Hosts    []string `strmap:"HOSTS,sep=;"`
+		Ints     *[]int
+		Array    [2]float64
+		Bytes    []byte
+		Timeouts []time.Duration
+	}
+
+	in := testCollection{
+		Hosts:    []string{"a", "b"},
+		Ints:     &[]int{1, 2},
+		Array:    [2]float64{0.5, 1.5},
+		Bytes:    []byte("raw"),
+		Timeouts: []time.Duration{time.Second},
+	}
+	want := map[string]string{
+		"HOSTS":    "a;b",
+		"Ints":     "1,2",
+		"Array":    "0.5,1.5",
+		"Bytes":    "raw",
+		"Timeouts": "1s",
+	}
+	got, err := EncodeStringMap(in, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+}
+
+func TestDecodeStringMapMap(t *testing.T) {
+	type testMap struct {
+		Labels   map[string]string
+		Limits   map[string]int `strmap:",sep=;,kvsep=:"`
+		Ports    map[int]*uint16
+		Timeouts *map[string]time.Duration
+		Empty    map[string]string
+	}
+
+	port := uint16(8080)
+	tests := []struct {
+		name    string
+		in      map[string]string
+		want    testMap
+		wantErr []string
+	}{
+		{
+			"normal",
+			map[string]string{
+				"Labels":   "team=core,tier=gold,expr=a=b",
+				"Limits":   "cpu:2;memory:512",
+				"Ports":    "1=8080",
+				"Timeouts": "read=1s",
+				"Empty":    "",
+			},
+			testMap{
+				Labels:   map[string]string{"team": "core", "tier": "gold", "expr": "a=b"},
+				Limits:   map[string]int{"cpu": 2, "memory": 512},
+				Ports:    map[int]*uint16{1: &port},
+				Timeouts: &map[string]time.Duration{"read": time.Second},
+				Empty:    map[string]string{},
+			},
+			nil,
+		},
+		{
+			"error",
+			map[string]string{
+				"Labels": "team",
+				"Limits": "cpu:x;memory:512",
+				"Ports":  "x=1",
+			},
+			testMap{},
+			[]string{"Labels", "Limits[cpu]", "Ports[x]"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got testMap
+			err := DecodeStringMap(tt.in, &got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeStringMapMap(t *testing.T) {
+	type testMap struct {
+		Labels map[string]string
+		Limits map[string]int `strmap:",sep=;,kvsep=:"`
+		Ports  *map[int]uint16
+	}
+
+	in := testMap{
+		Labels: map[string]string{"tier": "gold", "team": "core"},
+		Limits: map[string]int{"memory": 512, "cpu": 2},
+		Ports:  &map[int]uint16{1: 8080},
+	}
+	want := map[string]string{
+		"Labels": "team=core,tier=gold",
+		"Limits": "cpu:2;memory:512",
+		"Ports":  "1=8080",
+	}
+	got, err := EncodeStringMap(in, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+}
+
+func TestDecodeStringMapDefault(t *testing.T) {
+	type testDefaultNested struct {
+		Host string `strmap:",default=localhost"`
+	}
+	type testDefault struct {
+		Port     int               `strmap:",default=8080"`
+		Timeout  time.Duration     `strmap:",default=30s"`
+		Hosts    []string          `strmap:",sep=;,default=a;b"`
+		Labels   map[string]string `strmap:",sep=;,default=a=1;b=2"`
+		Ptr      *bool             `strmap:",default=true"`
+		Empty    string            `strmap:",default="`
+		Required string            `strmap:",required,default=r"`
+		Given    string            `strmap:",default=x"`
+		Nested   testDefaultNested
+		Commas   []string       `strmap:",default=a,b,c"`
+		Ints     [3]int         `strmap:",default=1,2,3,required"`
+		Pairs    map[string]int `strmap:",default=a=1,b=2,kvsep=="`
+	}
+	type testInvalidDefault struct {
+		Port  int      `strmap:",default=http"`
+		Ports []int    `strmap:",default=1;2,sep=;"`
+		Chan  chan int `strmap:",default=1"`
+	}
+
+	yes := true
+	tests := []struct {
+		name    string
+		in      map[string]string
+		got     interface{}
+		want    interface{}
+		wantErr []string
+	}{
+		{
+			"normal",
+			map[string]string{
+				"Given": "given",
+			},
+			&testDefault{Empty: "e"},
+			testDefault{
+				Port:     8080,
+				Timeout:  30 * time.Second,
+				Hosts:    []string{"a", "b"},
+				Labels:   map[string]string{"a": "1", "b": "2"},
+				Ptr:      &yes,
+				Empty:    "",
+				Required: "r",
+				Given:    "given",
+				Nested:   testDefaultNested{Host: "localhost"},
+				Commas:   []string{"a", "b", "c"},
+				Ints:     [3]int{1, 2, 3},
+				Pairs:    map[string]int{"a": 1, "b": 2},
+			},
+			nil,
+		},
+		{
+			"invalid default",
+			map[string]string{},
+			&testInvalidDefault{},
+			testInvalidDefault{Ports: []int{1, 2}},
+			[]string{"Chan", "Port"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := DecodeStringMap(tt.in, tt.got, nil)
+			if got := decodeErrorNames(err); !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.wantErr, got)
+			}
+			got := reflect.ValueOf(tt.got).Elem().Interface()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nwant = %+v\ngot  = %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeStringMapRecursive(t *testing.T) {
+	type testRecursive struct {
+		Name string
+		Next *testRecursive
+	}
+
+	var got testRecursive
+	if err := DecodeStringMap(map[string]string{"Name": "a"}, &got, nil); err != nil {
+		t.Error(err)
+	}
+	if want := (testRecursive{Name: "a"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant = %+v\ngot  = %+v", want, got)
+	}
+}